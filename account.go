@@ -0,0 +1,159 @@
+// SmartThings account handling for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/marcopaganini/gosmart"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/retry"
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// account holds the credentials for one SmartThings account.
+type account struct {
+	Name   string `yaml:"name"`
+	API    string `yaml:"api"`
+	Client string `yaml:"client"`
+	Secret string `yaml:"secret"`
+	Token  string `yaml:"token"`
+}
+
+// validate checks the account for errors and sets the default API.
+func (a *account) validate() error {
+	if a.API == "" {
+		a.API = "legacy"
+	}
+	switch a.API {
+	case "legacy":
+		if a.Client == "" {
+			return fmt.Errorf("must specify Client ID (--client)")
+		}
+	case "v1":
+		if a.Token == "" {
+			return fmt.Errorf("must specify a Personal Access Token (--token) with the v1 API")
+		}
+	default:
+		return fmt.Errorf("invalid API %q (valid values are \"v1\" or \"legacy\")", a.API)
+	}
+	return nil
+}
+
+// apiErrors counts the failed SmartThings API requests of all accounts.
+var apiErrors = &collector.ErrorCounter{}
+
+// newSource returns a collector.Source for the account, with retries, timeouts,
+// rate limiting and device caching set from the command-line flags. With
+// authOnly, only the OAuth authorization is performed (legacy API) and a nil
+// Source is returned.
+func newSource(ctx context.Context, acct account, authOnly bool) (collector.Source, error) {
+	var (
+		src       collector.Source
+		stc       *smartthings.Client
+		endpoint  string
+		cacheFile string
+	)
+
+	if err := acct.validate(); err != nil {
+		return nil, err
+	}
+
+	// All HTTP requests made for the account share the same rate limit.
+	var limiter *smartthings.RateLimiter
+	if *flagMaxRPS > 0 {
+		limiter = smartthings.NewRateLimiter(*flagMaxRPS)
+	}
+
+	switch acct.API {
+	case "legacy":
+		tfile := tokenFilePrefix + "_" + acct.Client + ".json"
+
+		// Create the oauth2.config object and get a token
+		config := gosmart.NewOAuthConfig(acct.Client, acct.Secret)
+		token, err := gosmart.GetToken(tfile, config)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching token: %v", err)
+		}
+		if authOnly {
+			slog.Info("Token saved", "file", tfile)
+			return nil, nil
+		}
+
+		// Create a client with the token and fetch endpoints URI, unless
+		// we have it in the device cache.
+		client := config.Client(ctx, token)
+		client.Timeout = *flagTimeout
+		if limiter != nil {
+			client.Transport = limiter.Transport(client.Transport)
+		}
+		cacheFile = tokenFilePrefix + "_" + acct.Client + "_devices.json"
+		if dc := loadDeviceCache(cacheFile, *flagDeviceCacheTTL); dc != nil && !*flagRefreshDevices {
+			endpoint = dc.Endpoint
+		}
+		if endpoint == "" {
+			err = retry.Do(ctx, *flagRetries, *flagRetryDelay, func() error {
+				var err error
+				endpoint, err = gosmart.GetEndPointsURI(client, gosmart.EndPointsURI)
+				if err != nil {
+					apiErrors.Add(1)
+				}
+				return collector.Permanent(err)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error reading endpoints URI: %v", err)
+			}
+		}
+		src = collector.NewLegacySource(client, endpoint)
+
+	case "v1":
+		if authOnly {
+			slog.Warn("The auth command is only used with the legacy API. Create a Personal Access Token at https://account.smartthings.com/tokens instead.")
+			return nil, nil
+		}
+		cacheFile = tokenFilePrefix + "_v1_devices.json"
+		if acct.Name != "" {
+			cacheFile = tokenFilePrefix + "_v1_" + acct.Name + "_devices.json"
+		}
+		stc = smartthings.NewClient(acct.Token)
+		stc.Timeout = *flagTimeout
+		stc.Limiter = limiter
+		v1 := collector.NewV1Source(stc)
+		v1.CustomCapabilities = *flagCustomCapabilities
+		src = v1
+		if *flagDeviceHealth {
+			src = collector.NewHealthSource(src, stc)
+		}
+	}
+
+	if *flagDeviceCacheTTL > 0 {
+		src = &devCacheSource{
+			Source:   src,
+			fname:    cacheFile,
+			ttl:      *flagDeviceCacheTTL,
+			endpoint: endpoint,
+			refresh:  *flagRefreshDevices,
+		}
+	}
+
+	// Location modes and inventory change often, so they are added outside
+	// of the device cache.
+	if stc != nil {
+		if *flagLocationMode {
+			src = collector.NewModeSource(src, stc)
+		}
+		if *flagInventory {
+			src = collector.NewInventorySource(src, stc)
+		}
+	}
+
+	// Each retry attempt has its own timeout.
+	src = collector.NewTimeoutSource(src, *flagTimeout)
+	src = collector.NewRetrySource(src, *flagRetries, *flagRetryDelay, apiErrors)
+	return src, nil
+}
@@ -0,0 +1,123 @@
+// Textfile snapshot archive for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Prefix and suffix of archived snapshots. The collection time goes in
+	// between, so names sort in chronological order.
+	archivePrefix = "smartcollector-"
+	archiveSuffix = ".prom.gz"
+
+	// Layout of the collection time in archive names (always UTC.)
+	archiveTimeLayout = "20060102T150405Z"
+)
+
+func init() {
+	sink.Register("archive", func() (sink.Sink, error) {
+		if *flagArchiveDir == "" {
+			return nil, nil
+		}
+		if err := os.MkdirAll(*flagArchiveDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating archive directory: %v", err)
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			if err := archiveTimeSeries(*flagArchiveDir, ts, t); err != nil {
+				return err
+			}
+			return rotateArchive(*flagArchiveDir, *flagArchiveMaxAge, *flagArchiveMaxSize<<20, t)
+		}), nil
+	})
+}
+
+// archiveTimeSeries saves a gzip compressed copy of the textfile collector
+// snapshot with the metrics collected at time t in dir.
+func archiveTimeSeries(dir string, ts []collector.Metric, t time.Time) error {
+	fname := filepath.Join(dir, archivePrefix+t.UTC().Format(archiveTimeLayout)+archiveSuffix)
+	tempfile := fname + ".tmp"
+
+	f, err := os.Create(tempfile)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	zw.Name = strings.TrimSuffix(filepath.Base(fname), ".gz")
+	zw.ModTime = t
+	err = writeTimeSeries(zw, ts)
+	if zerr := zw.Close(); err == nil {
+		err = zerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tempfile, fname)
+	}
+	if err != nil {
+		os.Remove(tempfile)
+		return err
+	}
+	return nil
+}
+
+// rotateArchive removes archived snapshots in dir older than maxAge (at time
+// now), and then the oldest snapshots until the total size is at most
+// maxSize bytes. The newest snapshot is never removed. Zero disables each
+// limit.
+func rotateArchive(dir string, maxAge time.Duration, maxSize int64, now time.Time) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	snapshots := []os.FileInfo{}
+	var total int64
+	for _, fi := range files {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), archivePrefix) && strings.HasSuffix(fi.Name(), archiveSuffix) {
+			snapshots = append(snapshots, fi)
+			total += fi.Size()
+		}
+	}
+	if len(snapshots) < 2 {
+		return nil
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name() < snapshots[j].Name() })
+
+	// Oldest first, skipping the newest.
+	removed := 0
+	for _, fi := range snapshots[:len(snapshots)-1] {
+		ts := strings.TrimSuffix(strings.TrimPrefix(fi.Name(), archivePrefix), archiveSuffix)
+		t, err := time.Parse(archiveTimeLayout, ts)
+		old := maxAge > 0 && err == nil && now.Sub(t) > maxAge
+		big := maxSize > 0 && total > maxSize
+		if !old && !big {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			return fmt.Errorf("error removing old snapshot: %v", err)
+		}
+		total -= fi.Size()
+		removed++
+	}
+	if removed > 0 {
+		slog.Debug("Archive rotated", "dir", dir, "removed", removed, "size", total)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Textfile snapshot archive for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestArchiveTimeSeries(t *testing.T) {
+	dir := t.TempDir()
+	ts := []collector.Metric{
+		{Name: "smartthings_switch_on", Labels: []collector.Label{{Name: "name", Value: "Lamp"}}, Value: 1, Help: "Whether the switch is on."},
+	}
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	if err := archiveTimeSeries(dir, ts, now); err != nil {
+		t.Fatalf("archiveTimeSeries failed: %v", err)
+	}
+
+	// Names use the UTC time, and no temporary files are left behind.
+	names := archiveNames(t, dir)
+	want := []string{"smartcollector-20160501T150000Z.prom.gz"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("archive files = %v, want %v", names, want)
+	}
+
+	f, err := os.Open(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("invalid gzip file: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("invalid gzip file: %v", err)
+	}
+	snapshot := &bytes.Buffer{}
+	if err := writeTimeSeries(snapshot, ts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, snapshot.Bytes()) {
+		t.Errorf("archived snapshot = %q, want %q", got, snapshot.Bytes())
+	}
+}
+
+func TestRotateArchive(t *testing.T) {
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	snapshot := func(age time.Duration) string {
+		return archivePrefix + now.Add(-age).Format(archiveTimeLayout) + archiveSuffix
+	}
+
+	casetests := []struct {
+		name    string
+		maxAge  time.Duration
+		maxSize int64
+		want    []string
+	}{
+		{
+			name: "no limits",
+			want: []string{snapshot(4 * time.Hour), snapshot(3 * time.Hour), snapshot(2 * time.Hour), snapshot(time.Hour), snapshot(0)},
+		},
+		{
+			name:   "max age",
+			maxAge: 150 * time.Minute,
+			want:   []string{snapshot(2 * time.Hour), snapshot(time.Hour), snapshot(0)},
+		},
+		{
+			name:    "max size",
+			maxSize: 250,
+			want:    []string{snapshot(time.Hour), snapshot(0)},
+		},
+		{
+			name:    "newest is kept",
+			maxAge:  time.Second,
+			maxSize: 1,
+			want:    []string{snapshot(0)},
+		},
+	}
+
+	for _, tt := range casetests {
+		// Five 100 byte snapshots, one hour apart, and an unrelated file.
+		dir := t.TempDir()
+		for _, age := range []time.Duration{0, time.Hour, 2 * time.Hour, 3 * time.Hour, 4 * time.Hour} {
+			if err := os.WriteFile(filepath.Join(dir, snapshot(age)), make([]byte, 100), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "notes.txt"), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rotateArchive(dir, tt.maxAge, tt.maxSize, now); err != nil {
+			t.Errorf("%s: rotateArchive failed: %v", tt.name, err)
+		}
+		want := append([]string{"notes.txt"}, tt.want...)
+		sort.Strings(want)
+		if got := archiveNames(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: files = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+// archiveNames returns the sorted names of all files in dir.
+func archiveNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,53 @@
+// Button press counter persistence for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+// File holding the button press counters between runs.
+const buttonStateFile = tokenFilePrefix + "_buttons.json"
+
+// loadButtons returns a button counter with the state saved in fname. A new
+// counter is returned if the file does not exist or cannot be read.
+func loadButtons(fname string) *collector.ButtonCounter {
+	b := collector.NewButtonCounter()
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return b
+	}
+	if err := json.Unmarshal(buf, b); err != nil {
+		slog.Warn("Ignoring invalid button state", "file", fname, "error", err)
+		return collector.NewButtonCounter()
+	}
+	return b
+}
+
+// saveButtons writes the state of the button counter to fname atomically.
+// Nothing is written until the first button device is seen.
+func saveButtons(fname string, b *collector.ButtonCounter) {
+	if b == nil || b.Len() == 0 {
+		return
+	}
+	buf, err := json.Marshal(b)
+	if err == nil {
+		tempfile := fname + ".tmp"
+		if err = ioutil.WriteFile(tempfile, buf, 0600); err == nil {
+			if err = os.Rename(tempfile, fname); err != nil {
+				os.Remove(tempfile)
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("Error saving button state", "file", fname, "error", err)
+	}
+}
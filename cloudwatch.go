@@ -0,0 +1,103 @@
+// AWS CloudWatch support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Maximum number of metrics in a PutMetricData request.
+	cloudwatchBatchSize = 1000
+
+	// Maximum number of dimensions per metric.
+	cloudwatchMaxDimensions = 30
+)
+
+// cloudwatchAPI is the part of the CloudWatch client used to publish metrics.
+type cloudwatchAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+func init() {
+	sink.Register("cloudwatch", func() (sink.Sink, error) {
+		if *flagCloudWatchNamespace == "" {
+			return nil, nil
+		}
+		dims := []string{}
+		if *flagCloudWatchDimensions != "" {
+			dims = strings.Split(*flagCloudWatchDimensions, ",")
+		}
+		// Credentials and region come from the usual AWS sources
+		// (environment, shared configuration files, instance roles, etc.)
+		ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+		defer cancel()
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		client := cloudwatch.NewFromConfig(cfg)
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return putCloudWatch(ctx, client, *flagCloudWatchNamespace, dims, ts, t)
+		})), nil
+	})
+}
+
+// cloudwatchDatum returns a metric collected at time t as a CloudWatch
+// metric datum. Non-empty labels become dimensions, limited to the labels in
+// dims, if not empty. Labels beyond the CloudWatch limit of dimensions are
+// ignored.
+func cloudwatchDatum(m collector.Metric, dims []string, t time.Time) types.MetricDatum {
+	d := types.MetricDatum{
+		MetricName: aws.String(m.Name),
+		Timestamp:  aws.Time(t),
+		Value:      aws.Float64(m.Value),
+		Unit:       types.StandardUnitNone,
+	}
+	for _, l := range m.Labels {
+		if l.Value == "" || (len(dims) > 0 && !contains(dims, l.Name)) {
+			continue
+		}
+		if len(d.Dimensions) == cloudwatchMaxDimensions {
+			break
+		}
+		d.Dimensions = append(d.Dimensions, types.Dimension{Name: aws.String(l.Name), Value: aws.String(l.Value)})
+	}
+	return d
+}
+
+// putCloudWatch publishes the metrics collected at time t as CloudWatch
+// custom metrics in the given namespace, in batches of cloudwatchBatchSize
+// metrics.
+func putCloudWatch(ctx context.Context, client cloudwatchAPI, namespace string, dims []string, ts []collector.Metric, t time.Time) error {
+	for start := 0; start < len(ts); start += cloudwatchBatchSize {
+		end := start + cloudwatchBatchSize
+		if end > len(ts) {
+			end = len(ts)
+		}
+		data := []types.MetricDatum{}
+		for _, m := range ts[start:end] {
+			data = append(data, cloudwatchDatum(m, dims, t))
+		}
+		_, err := client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(namespace),
+			MetricData: data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// AWS CloudWatch support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// fakeCloudWatch records the number of metrics in each PutMetricData
+// request, failing the request number fail (if not zero).
+type fakeCloudWatch struct {
+	batches []int
+	fail    int
+}
+
+func (c *fakeCloudWatch) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	c.batches = append(c.batches, len(params.MetricData))
+	if len(c.batches) == c.fail {
+		return nil, errors.New("throttled")
+	}
+	if ns := aws.ToString(params.Namespace); ns != "SmartThings" {
+		return nil, fmt.Errorf("unexpected namespace %q", ns)
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchDatum(t *testing.T) {
+	now := time.Unix(1462096800, 0)
+	m := collector.Metric{
+		Name:   "smartthings_temperature_celsius",
+		Labels: []collector.Label{{Name: "id", Value: "d1"}, {Name: "name", Value: "Fridge"}, {Name: "hub", Value: ""}, {Name: "room", Value: "Kitchen"}},
+		Value:  4.5,
+	}
+	many := collector.Metric{Name: "m"}
+	for n := 0; n < 40; n++ {
+		many.Labels = append(many.Labels, collector.Label{Name: fmt.Sprintf("l%d", n), Value: "v"})
+	}
+
+	casetests := []struct {
+		name     string
+		metric   collector.Metric
+		dims     []string
+		wantDims []string
+	}{
+		{
+			name:     "all labels",
+			metric:   m,
+			wantDims: []string{"id=d1", "name=Fridge", "room=Kitchen"},
+		},
+		{
+			name:     "selected dimensions",
+			metric:   m,
+			dims:     []string{"name", "hub"},
+			wantDims: []string{"name=Fridge"},
+		},
+		{
+			name:   "too many labels",
+			metric: many,
+		},
+	}
+
+	for _, tt := range casetests {
+		d := cloudwatchDatum(tt.metric, tt.dims, now)
+		if aws.ToString(d.MetricName) != tt.metric.Name || aws.ToFloat64(d.Value) != tt.metric.Value || !aws.ToTime(d.Timestamp).Equal(now) || d.Unit != types.StandardUnitNone {
+			t.Errorf("%s: unexpected datum %+v", tt.name, d)
+		}
+		got := []string{}
+		for _, dim := range d.Dimensions {
+			got = append(got, aws.ToString(dim.Name)+"="+aws.ToString(dim.Value))
+		}
+		if tt.wantDims == nil {
+			if len(got) != cloudwatchMaxDimensions {
+				t.Errorf("%s: got %d dimensions, want %d", tt.name, len(got), cloudwatchMaxDimensions)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.wantDims) {
+			t.Errorf("%s: dimensions = %v, want %v", tt.name, got, tt.wantDims)
+		}
+	}
+}
+
+func TestPutCloudWatch(t *testing.T) {
+	ts := make([]collector.Metric, 2500)
+	for n := range ts {
+		ts[n] = collector.Metric{Name: fmt.Sprintf("m%d", n), Value: float64(n)}
+	}
+
+	casetests := []struct {
+		name    string
+		fail    int
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "batches",
+			want: []int{1000, 1000, 500},
+		},
+		{
+			name:    "failed batch stops",
+			fail:    2,
+			want:    []int{1000, 1000},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		client := &fakeCloudWatch{fail: tt.fail}
+		err := putCloudWatch(context.Background(), client, "SmartThings", nil, ts, time.Now())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: putCloudWatch error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(client.batches, tt.want) {
+			t.Errorf("%s: batches = %v, want %v", tt.name, client.batches, tt.want)
+		}
+	}
+}
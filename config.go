@@ -0,0 +1,348 @@
+// Configuration file support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// config holds the contents of the YAML configuration file. Empty values mean
+// "not set" and leave the corresponding command-line flag untouched.
+type config struct {
+	Client      string                 `yaml:"client"`
+	Secret      string                 `yaml:"secret"`
+	API         string                 `yaml:"api"`
+	Token       string                 `yaml:"token"`
+	TextFileDir string                 `yaml:"textfile_dir"`
+	Location    string                 `yaml:"location"`
+	Interval    string                 `yaml:"interval"`
+	Accounts    []account              `yaml:"accounts"`
+	Devices     deviceFilter           `yaml:"devices"`
+	Attributes  map[string]interface{} `yaml:"attributes"`
+	BatteryLow  batteryLowConfig       `yaml:"battery_low"`
+	Relabel     []relabelRule          `yaml:"relabel"`
+	NotifyURL   string                 `yaml:"notify_url"`
+	Notify      []notifyRule           `yaml:"notify"`
+
+	// DeviceLabels holds labels added to all metrics of the devices
+	// matching each pattern (a shell glob matched against the device ID and
+	// display name.)
+	DeviceLabels map[string]map[string]string `yaml:"device_labels"`
+
+	// Parsed versions of the fields above, filled by validate.
+	interval time.Duration
+	mappings map[string]collector.Converter
+}
+
+// batteryLowConfig holds the battery levels (in percent) below which the
+// battery of a device is considered low. Device thresholds (keyed by shell
+// globs matched against the device ID and display name) take precedence over
+// capability thresholds (keyed by an attribute reported by the device, like
+// "lock"), which take precedence over the global threshold.
+type batteryLowConfig struct {
+	Threshold    float64            `yaml:"threshold"`
+	Devices      map[string]float64 `yaml:"devices"`
+	Capabilities map[string]float64 `yaml:"capabilities"`
+}
+
+// deviceFilter selects which devices are collected. Patterns are shell
+// globs matched against the device ID and display name. An empty include list
+// selects all devices. If capabilities is not empty, only devices supporting
+// at least one of them (in any component) are collected.
+type deviceFilter struct {
+	Include      []string `yaml:"include"`
+	Exclude      []string `yaml:"exclude"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// loadConfig reads and validates the YAML configuration file in fname.
+func loadConfig(fname string) (*config, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := yaml.UnmarshalStrict(buf, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the configuration for errors and fills the parsed fields.
+func (c *config) validate() error {
+	if c.API != "" && c.API != "v1" && c.API != "legacy" {
+		return fmt.Errorf("api: invalid value %q (valid values are \"v1\" or \"legacy\")", c.API)
+	}
+
+	names := map[string]bool{}
+	for n := range c.Accounts {
+		acct := &c.Accounts[n]
+		if acct.Name == "" {
+			return fmt.Errorf("accounts: account #%d has no name", n+1)
+		}
+		if names[acct.Name] {
+			return fmt.Errorf("accounts: duplicate account name %q", acct.Name)
+		}
+		names[acct.Name] = true
+		if err := acct.validate(); err != nil {
+			return fmt.Errorf("accounts: %s: %v", acct.Name, err)
+		}
+	}
+
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return fmt.Errorf("interval: invalid duration %q (use values like \"30s\" or \"5m\")", c.Interval)
+		}
+		if d <= 0 {
+			return fmt.Errorf("interval: must be positive, got %q", c.Interval)
+		}
+		c.interval = d
+	}
+
+	for _, pattern := range append(c.Devices.Include, c.Devices.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("devices: invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	if err := validThreshold(c.BatteryLow.Threshold); err != nil {
+		return fmt.Errorf("battery_low: threshold: %v", err)
+	}
+	for pattern, t := range c.BatteryLow.Devices {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("battery_low: devices: invalid pattern %q: %v", pattern, err)
+		}
+		if err := validThreshold(t); err != nil {
+			return fmt.Errorf("battery_low: devices: %s: %v", pattern, err)
+		}
+	}
+	for attr, t := range c.BatteryLow.Capabilities {
+		if err := validThreshold(t); err != nil {
+			return fmt.Errorf("battery_low: capabilities: %s: %v", attr, err)
+		}
+	}
+
+	for pattern, labels := range c.DeviceLabels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("device_labels: invalid pattern %q: %v", pattern, err)
+		}
+		for name := range labels {
+			if !validLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("device_labels: %s: invalid label name %q", pattern, name)
+			}
+		}
+	}
+
+	for n := range c.Relabel {
+		if err := c.Relabel[n].validate(); err != nil {
+			return fmt.Errorf("relabel: rule #%d: %v", n+1, err)
+		}
+	}
+
+	for n := range c.Notify {
+		if err := c.Notify[n].validate(); err != nil {
+			return fmt.Errorf("notify: rule #%d: %v", n+1, err)
+		}
+	}
+
+	c.mappings = map[string]collector.Converter{}
+	for attr, v := range c.Attributes {
+		conv, err := newConverter(v)
+		if err != nil {
+			return fmt.Errorf("attributes: %s: %v", attr, err)
+		}
+		c.mappings[attr] = conv
+	}
+	return nil
+}
+
+// validThreshold returns an error if t is not a valid battery percentage.
+func validThreshold(t float64) error {
+	if t < 0 || t > 100 {
+		return fmt.Errorf("must be between 0 and 100, got %v", t)
+	}
+	return nil
+}
+
+// newConverter returns a converter from an attribute mapping in the
+// configuration file. Valid mappings are "float", "clear", a list of two
+// strings representing the values for 0 and 1 (e.g. ["off", "on"]), or a map
+// of strings to numbers (e.g. {closed: 0, open: 1}).
+func newConverter(v interface{}) (collector.Converter, error) {
+	switch m := v.(type) {
+	case string:
+		switch m {
+		case "float":
+			return collector.ValueFloat, nil
+		case "clear":
+			return collector.ValueClear, nil
+		}
+		return nil, fmt.Errorf("unknown mapping %q (valid mappings are \"float\", \"clear\", a list of two values or a map of values)", m)
+	case []interface{}:
+		if len(m) != 2 {
+			return nil, fmt.Errorf("value lists must have exactly two items, got %d", len(m))
+		}
+		options := []string{}
+		for _, o := range m {
+			s, ok := o.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid non-string value %v in list", o)
+			}
+			options = append(options, s)
+		}
+		return func(v interface{}) (float64, error) {
+			return collector.ValueOneOf(v, options)
+		}, nil
+	case map[interface{}]interface{}:
+		values := map[string]float64{}
+		for k, n := range m {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid non-string value %v in map (quote values like on, off, yes or no)", k)
+			}
+			switch f := n.(type) {
+			case int:
+				values[s] = float64(f)
+			case float64:
+				values[s] = f
+			default:
+				return nil, fmt.Errorf("invalid non-numeric value %v for %q", n, s)
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("value maps cannot be empty")
+		}
+		return func(v interface{}) (float64, error) {
+			return collector.ValueMap(v, values)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid mapping %v", v)
+}
+
+// wantDevice returns true if the device should be collected, according to
+// the device filters.
+func (c *config) wantDevice(dev collector.Device) bool {
+	if len(c.Devices.Include) > 0 && !matchAny(c.Devices.Include, dev.ID, dev.Name) {
+		return false
+	}
+	if len(c.Devices.Capabilities) > 0 && !hasCapability(dev, c.Devices.Capabilities) {
+		return false
+	}
+	return !matchAny(c.Devices.Exclude, dev.ID, dev.Name)
+}
+
+// hasCapability returns true if any component of the device supports any of
+// the capabilities.
+func hasCapability(dev collector.Device, capabilities []string) bool {
+	for _, caps := range dev.Capabilities {
+		for _, c := range caps {
+			if contains(capabilities, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// batteryLow returns the low battery threshold for a device with the given
+// attributes, or def if no specific threshold is configured. Patterns and
+// capabilities are checked in lexical order.
+func (c *config) batteryLow(dev collector.Device, attrs map[string]interface{}, def float64) float64 {
+	for _, pattern := range sortedKeys(c.BatteryLow.Devices) {
+		if matchAny([]string{pattern}, dev.ID, dev.Name) {
+			return c.BatteryLow.Devices[pattern]
+		}
+	}
+	for _, attr := range sortedKeys(c.BatteryLow.Capabilities) {
+		if _, ok := attrs[attr]; ok {
+			return c.BatteryLow.Capabilities[attr]
+		}
+	}
+	return def
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]float64) []string {
+	ret := []string{}
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// matchAny returns true if any of the strings in values matches any of the
+// glob patterns.
+func matchAny(patterns []string, values ...string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if ok, _ := path.Match(p, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Environment variable names for flags that don't follow the
+// SMARTCOLLECTOR_<FLAG_NAME> convention.
+var envNames = map[string]string{
+	"client": "SMARTCOLLECTOR_CLIENT_ID",
+	"secret": "SMARTCOLLECTOR_CLIENT_SECRET",
+}
+
+// envName returns the name of the environment variable for a flag. By default,
+// this is the flag name in uppercase with dashes converted to underscores and
+// prefixed by SMARTCOLLECTOR_ (e.g., textfile-dir -> SMARTCOLLECTOR_TEXTFILE_DIR).
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+	return "SMARTCOLLECTOR_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// mergeEnv sets the value of all flags not explicitly set in the command
+// line from the corresponding environment variable, when present.
+func mergeEnv() error {
+	set := setFlags()
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if e := flag.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), e)
+			}
+		}
+	})
+	return err
+}
+
+// setFlags returns the names of all flags explicitly set (in the command line
+// or environment).
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
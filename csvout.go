@@ -0,0 +1,119 @@
+// CSV output for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("csv", func() (sink.Sink, error) {
+		if *flagCSVFile == "" {
+			return nil, nil
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return appendCSV(*flagCSVFile, ts, t)
+		}), nil
+	})
+}
+
+// csvHeader holds the names of the CSV columns.
+var csvHeader = []string{"time", "device", "attribute", "metric", "value", "labels"}
+
+// csvRow returns the CSV row for a metric collected at time t. The labels
+// column holds all non-empty labels other than the device name, as
+// semicolon separated name=value pairs.
+func csvRow(m collector.Metric, t time.Time) []string {
+	labels := []string{}
+	for _, l := range m.Labels {
+		if l.Name == "name" || l.Value == "" {
+			continue
+		}
+		labels = append(labels, l.Name+"="+l.Value)
+	}
+	return []string{
+		t.UTC().Format(time.RFC3339),
+		m.Label("name"),
+		m.Attribute,
+		m.Name,
+		strconv.FormatFloat(m.Value, 'g', -1, 64),
+		strings.Join(labels, ";"),
+	}
+}
+
+// writeCSV writes a header and one CSV row for every metric to w.
+func writeCSV(w io.Writer, ts []collector.Metric, t time.Time) error {
+	return writeCSVRows(w, ts, t, true)
+}
+
+// writeCSVRows writes one CSV row for every metric to w, preceded by a header
+// if header is true.
+func writeCSVRows(w io.Writer, ts []collector.Metric, t time.Time, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	for _, m := range ts {
+		if err := cw.Write(csvRow(m, t)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvStdoutHeader makes sure the CSV header is only printed once to stdout.
+var csvStdoutHeader sync.Once
+
+// appendCSV appends one CSV row for every metric collected at time t to the
+// file fname (or stdout, if fname is "-"), creating the file if needed. The
+// header is only written to new (or empty) files, and once to stdout. Rows
+// are written with a single call, so readers never see partial collections.
+func appendCSV(fname string, ts []collector.Metric, t time.Time) error {
+	if fname == "-" {
+		header := false
+		csvStdoutHeader.Do(func() { header = true })
+		buf := &bytes.Buffer{}
+		if err := writeCSVRows(buf, ts, t, header); err != nil {
+			return err
+		}
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := writeCSVRows(buf, ts, t, fi.Size() == 0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
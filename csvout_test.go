@@ -0,0 +1,45 @@
+// CSV output for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestAppendCSV(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "readings.csv")
+	ts := []collector.Metric{
+		{
+			Name:      "smartthings_contact_open",
+			Labels:    []collector.Label{{Name: "id", Value: "d1"}, {Name: "name", Value: "Front, door"}, {Name: "room", Value: ""}},
+			Value:     1,
+			Attribute: "contact",
+		},
+	}
+	t1 := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, tm := range []time.Time{t1, t1.Add(time.Minute)} {
+		if err := appendCSV(fname, ts, tm); err != nil {
+			t.Fatalf("appendCSV failed: %v", err)
+		}
+	}
+
+	got, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "time,device,attribute,metric,value,labels\n" +
+		"2016-05-01T10:00:00Z,\"Front, door\",contact,smartthings_contact_open,1,id=d1\n" +
+		"2016-05-01T10:01:00Z,\"Front, door\",contact,smartthings_contact_open,1,id=d1\n"
+	if string(got) != want {
+		t.Errorf("file contents:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Device list caching for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// deviceCache holds the device list and endpoint URI saved between runs.
+type deviceCache struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Endpoint  string             `json:"endpoint,omitempty"`
+	Devices   []collector.Device `json:"devices"`
+}
+
+// loadDeviceCache reads the device cache from fname. It returns nil if the
+// file does not exist, cannot be read, or is older than ttl.
+func loadDeviceCache(fname string, ttl time.Duration) *deviceCache {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil
+	}
+	dc := &deviceCache{}
+	if err := json.Unmarshal(buf, dc); err != nil {
+		slog.Warn("Ignoring invalid device cache", "file", fname, "error", err)
+		return nil
+	}
+	if time.Since(dc.Timestamp) > ttl {
+		return nil
+	}
+	return dc
+}
+
+// save writes the device cache to fname atomically.
+func (dc *deviceCache) save(fname string) error {
+	buf, err := json.Marshal(dc)
+	if err != nil {
+		return err
+	}
+	tempfile := fname + ".tmp"
+	if err := ioutil.WriteFile(tempfile, buf, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tempfile, fname); err != nil {
+		os.Remove(tempfile)
+		return err
+	}
+	return nil
+}
+
+// devCacheSource wraps a Source, reading the device list from the device
+// cache file while it is fresh and saving it to the file otherwise.
+type devCacheSource struct {
+	collector.Source
+
+	fname    string
+	ttl      time.Duration
+	endpoint string
+
+	// refresh forces the next call to Devices to bypass the cache.
+	refresh bool
+}
+
+func (s *devCacheSource) Devices(ctx context.Context) ([]collector.Device, error) {
+	if !s.refresh {
+		if dc := loadDeviceCache(s.fname, s.ttl); dc != nil && dc.Devices != nil {
+			slog.Debug("Using cached device list", "file", s.fname, "devices", len(dc.Devices))
+			return dc.Devices, nil
+		}
+	}
+	devs, err := s.Source.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.refresh = false
+
+	dc := &deviceCache{
+		Timestamp: time.Now(),
+		Endpoint:  s.endpoint,
+		Devices:   devs,
+	}
+	if err := dc.save(s.fname); err != nil {
+		slog.Error("Error saving device cache", "file", s.fname, "error", err)
+	}
+	return devs, nil
+}
@@ -0,0 +1,228 @@
+// State change events for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+const (
+	// File holding the last state of the event attributes between runs.
+	eventStateFile = tokenFilePrefix + "_events.json"
+
+	// Socket of the journald native protocol.
+	journalSocket = "/run/systemd/journal/socket"
+
+	// Identifier of smartcollector messages in syslog and the journal.
+	eventIdentifier = "smartcollector"
+)
+
+// Sockets of the local syslog daemon, in order of preference.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func init() {
+	sink.Register("events", func() (sink.Sink, error) {
+		var send func([]stateEvent) error
+		switch *flagEvents {
+		case "":
+			return nil, nil
+		case "syslog":
+			send = sendSyslog
+		case "journal":
+			send = sendJournal
+		default:
+			return nil, fmt.Errorf("invalid event destination %q (valid destinations are syslog and journal)", *flagEvents)
+		}
+		attrs := strings.Split(*flagEventAttributes, ",")
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return logStateChanges(attrs, eventStateFile, send, ts, t)
+		}), nil
+	})
+}
+
+// stateEvent is a change in the state of a device attribute.
+type stateEvent struct {
+	DeviceID  string
+	Device    string
+	Location  string
+	Room      string
+	Component string
+	Attribute string
+	From      string
+	To        string
+	Time      time.Time
+}
+
+// String returns the event as a human readable message, followed by all
+// fields as key=value pairs (e.g. `Front door contact changed from closed to
+// open device_id=... device="Front door" ...`).
+func (e stateEvent) String() string {
+	msg := fmt.Sprintf("%s %s changed from %s to %s", e.Device, e.Attribute, e.From, e.To)
+	for _, f := range e.fields() {
+		msg += " " + strings.ToLower(f[0]) + "=" + logfmtValue(f[1])
+	}
+	return msg
+}
+
+// fields returns the names (in uppercase, as journal fields) and values of
+// the event fields.
+func (e stateEvent) fields() [][2]string {
+	return [][2]string{
+		{"DEVICE_ID", e.DeviceID},
+		{"DEVICE", e.Device},
+		{"LOCATION", e.Location},
+		{"ROOM", e.Room},
+		{"COMPONENT", e.Component},
+		{"ATTRIBUTE", e.Attribute},
+		{"FROM", e.From},
+		{"TO", e.To},
+	}
+}
+
+// logfmtValue returns s, quoted if needed.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logStateChanges sends an event for every attribute in attrs whose state
+// changed since the last run. The last state of each device attribute is
+// kept in stateFile. Attributes seen for the first time only have their
+// state recorded.
+func logStateChanges(attrs []string, stateFile string, send func([]stateEvent) error, ts []collector.Metric, t time.Time) error {
+	state := map[string]string{}
+	if err := loadStateFile(stateFile, &state); err != nil {
+		slog.Warn("Ignoring invalid event state", "file", stateFile, "error", err)
+		state = map[string]string{}
+	}
+
+	events := []stateEvent{}
+	seen := map[string]bool{}
+	for _, m := range ts {
+		if m.State == "" || !contains(attrs, m.Attribute) {
+			continue
+		}
+		key := strings.Join([]string{m.Label("account"), m.Label("id"), m.Label("component"), m.Attribute}, "/")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if prev, ok := state[key]; ok && prev != m.State {
+			events = append(events, stateEvent{
+				DeviceID:  m.Label("id"),
+				Device:    m.Label("name"),
+				Location:  m.Label("location"),
+				Room:      m.Label("room"),
+				Component: m.Label("component"),
+				Attribute: m.Attribute,
+				From:      prev,
+				To:        m.State,
+				Time:      t,
+			})
+		}
+		state[key] = m.State
+	}
+
+	// Forget devices and attributes no longer present.
+	for key := range state {
+		if !seen[key] {
+			delete(state, key)
+		}
+	}
+
+	// Events are only sent once, even if sending fails.
+	if err := saveStateFile(stateFile, state); err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return send(events)
+}
+
+// dialUnixgram connects to the first datagram socket in names that accepts
+// the connection.
+func dialUnixgram(names ...string) (net.Conn, error) {
+	var err error
+	for _, name := range names {
+		var conn net.Conn
+		if conn, err = net.Dial("unixgram", name); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// sendSyslog sends the events to the local syslog daemon (facility daemon,
+// priority info), one message per event.
+func sendSyslog(events []stateEvent) error {
+	conn, err := dialUnixgram(syslogSockets...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// <30> is facility daemon (3), severity info (6).
+	for _, e := range events {
+		msg := fmt.Sprintf("<30>%s %s[%d]: %s", e.Time.Format(time.Stamp), eventIdentifier, os.Getpid(), e)
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendJournal sends the events to the systemd journal using its native
+// protocol, with the event fields prefixed by SMARTTHINGS_ (e.g.,
+// SMARTTHINGS_ATTRIBUTE=contact) for filtering with journalctl.
+func sendJournal(events []stateEvent) error {
+	conn, err := dialUnixgram(journalSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, e := range events {
+		msg := &bytes.Buffer{}
+		journalField(msg, "MESSAGE", fmt.Sprintf("%s %s changed from %s to %s", e.Device, e.Attribute, e.From, e.To))
+		journalField(msg, "PRIORITY", "6")
+		journalField(msg, "SYSLOG_IDENTIFIER", eventIdentifier)
+		for _, f := range e.fields() {
+			journalField(msg, "SMARTTHINGS_"+f[0], f[1])
+		}
+		if _, err := conn.Write(msg.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalField appends a field in the journal native format to buf. Values
+// with newlines use the binary (length prefixed) format.
+func journalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
@@ -0,0 +1,83 @@
+// State change events for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestLogStateChanges(t *testing.T) {
+	contact := func(id, state string) collector.Metric {
+		return collector.Metric{
+			Name:      "smartthings_contact_open",
+			Labels:    []collector.Label{{Name: "id", Value: id}, {Name: "name", Value: "Door " + id}},
+			Attribute: "contact",
+			State:     state,
+		}
+	}
+	motion := collector.Metric{
+		Name:      "smartthings_motion_active",
+		Labels:    []collector.Label{{Name: "id", Value: "m1"}},
+		Attribute: "motion",
+		State:     "active",
+	}
+
+	// Runs share the same state file, in order.
+	casetests := []struct {
+		name string
+		ts   []collector.Metric
+		want []string
+	}{
+		{
+			name: "first run",
+			ts:   []collector.Metric{contact("d1", "closed"), motion},
+		},
+		{
+			name: "no change",
+			ts:   []collector.Metric{contact("d1", "closed"), motion},
+		},
+		{
+			name: "change",
+			ts:   []collector.Metric{contact("d1", "open"), contact("d2", "open")},
+			want: []string{"d1:closed:open"},
+		},
+		{
+			name: "device removed",
+			ts:   []collector.Metric{contact("d2", "closed")},
+			want: []string{"d2:open:closed"},
+		},
+		{
+			name: "device back",
+			ts:   []collector.Metric{contact("d1", "closed"), contact("d2", "closed")},
+		},
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "events.json")
+	t1 := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, tt := range casetests {
+		var got []string
+		send := func(events []stateEvent) error {
+			for _, e := range events {
+				if !e.Time.Equal(t1) {
+					t.Errorf("%s: event time = %v, want %v", tt.name, e.Time, t1)
+				}
+				got = append(got, e.DeviceID+":"+e.From+":"+e.To)
+			}
+			return nil
+		}
+		if err := logStateChanges([]string{"contact"}, stateFile, send, tt.ts, t1); err != nil {
+			t.Fatalf("%s: logStateChanges failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: events = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Prometheus exposition format output for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newRegistry returns a Prometheus registry holding the given metrics, with
+// one gauge (or counter) vector per metric name. Metrics of the same name missing some of
+// the labels used by others get empty values for those labels. The help text
+// of each family comes from the first metric of that name with one. If
+// timestamps is true, samples with a known update time (see
+// collector.Metric.Time) carry it as their timestamp.
+func newRegistry(ts []collector.Metric, timestamps bool) (prometheus.Gatherer, error) {
+	// Label names for each metric family, in order of first appearance.
+	names := []string{}
+	labels := map[string][]string{}
+	help := map[string]string{}
+	counter := map[string]bool{}
+	for _, m := range ts {
+		if _, ok := labels[m.Name]; !ok {
+			names = append(names, m.Name)
+			labels[m.Name] = []string{}
+		}
+		if help[m.Name] == "" {
+			help[m.Name] = m.Help
+		}
+		counter[m.Name] = counter[m.Name] || m.Counter
+		for _, l := range m.Labels {
+			if !contains(labels[m.Name], l.Name) {
+				labels[m.Name] = append(labels[m.Name], l.Name)
+			}
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	gauges := map[string]*prometheus.GaugeVec{}
+	counters := map[string]*prometheus.CounterVec{}
+	for _, name := range names {
+		var c prometheus.Collector
+		if counter[name] {
+			counters[name] = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help[name]}, labels[name])
+			c = counters[name]
+		} else {
+			gauges[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help[name]}, labels[name])
+			c = gauges[name]
+		}
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("error registering %s: %v", name, err)
+		}
+	}
+
+	times := map[string]int64{}
+	for _, m := range ts {
+		values := prometheus.Labels{}
+		for _, l := range labels[m.Name] {
+			values[l] = m.Label(l)
+		}
+		if timestamps && !m.Time.IsZero() {
+			times[seriesKey(m.Name, values)] = m.Time.UnixNano() / 1e6
+		}
+		if counter[m.Name] {
+			// The registry is new, so adding sets the value of the counter.
+			c, err := counters[m.Name].GetMetricWith(values)
+			if err != nil {
+				return nil, fmt.Errorf("error creating %s: %v", m.Name, err)
+			}
+			// Counters can't be set to negative values. Decreasing values
+			// (e.g., a meter reset) are fine: Prometheus treats them as
+			// counter resets in rate() and increase().
+			if m.Value < 0 {
+				slog.Warn("Dropping negative counter value", "metric", m.Name, "id", m.Label("id"), "value", m.Value)
+				continue
+			}
+			c.Add(m.Value)
+			continue
+		}
+		g, err := gauges[m.Name].GetMetricWith(values)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s: %v", m.Name, err)
+		}
+		g.Set(m.Value)
+	}
+	if len(times) > 0 {
+		return &timestampGatherer{Gatherer: reg, times: times}, nil
+	}
+	return reg, nil
+}
+
+// timestampGatherer sets the timestamp of the samples gathered from a
+// registry, keyed by seriesKey. Samples not present in times are left
+// without a timestamp.
+type timestampGatherer struct {
+	prometheus.Gatherer
+	times map[string]int64
+}
+
+// Gather implements prometheus.Gatherer.
+func (g *timestampGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			values := prometheus.Labels{}
+			for _, lp := range m.Label {
+				values[lp.GetName()] = lp.GetValue()
+			}
+			if t, ok := g.times[seriesKey(mf.GetName(), values)]; ok {
+				m.TimestampMs = &t
+			}
+		}
+	}
+	return mfs, nil
+}
+
+// seriesKey returns a string identifying a time series by metric name and
+// label values.
+func seriesKey(name string, values prometheus.Labels) string {
+	names := []string{}
+	for l := range values {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+
+	key := []string{name}
+	for _, l := range names {
+		key = append(key, l+"="+values[l])
+	}
+	return strings.Join(key, "\xff")
+}
+
+// writeMetrics writes all metrics gathered from g to w in the Prometheus text
+// exposition format.
+func writeMetrics(w io.Writer, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTimeSeries writes the array of metrics to w in the Prometheus text
+// exposition format. Samples have no timestamps, since both the Pushgateway
+// and the node exporter textfile collector reject them.
+func writeTimeSeries(w io.Writer, ts []collector.Metric) error {
+	reg, err := newRegistry(ts, false)
+	if err != nil {
+		return err
+	}
+	return writeMetrics(w, reg)
+}
+
+// contains returns true if s is present in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Google Cloud Monitoring support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/retry"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// Maximum number of time series in a CreateTimeSeries request.
+	gcmBatchSize = 200
+
+	// Maximum number of labels in a custom metric.
+	gcmMaxLabels = 30
+)
+
+// gcmStartTime is the start time of cumulative metrics (counters). As with
+// OTLP, the process start time is used, since we don't know when device
+// counters were last reset.
+var gcmStartTime = time.Now()
+
+func init() {
+	sink.Register("gcm", func() (sink.Sink, error) {
+		if *flagGCMProject == "" {
+			return nil, nil
+		}
+		// Credentials come from the Application Default Credentials.
+		client, err := monitoring.NewMetricClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return withClose(withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeGCM(ctx, client, *flagGCMProject, *flagGCMMetricPrefix, *flagRetries, *flagRetryDelay, ts, t)
+		})), client.Close), nil
+	})
+}
+
+// gcmTimeSeries returns a metric collected at time t as a Cloud Monitoring
+// time series with a single point. The metric type is the metric name with
+// prefix, and non-empty labels become metric labels (up to the limit of
+// labels per metric.) Counters are written as cumulative metrics starting at
+// gcmStartTime, other metrics as gauges. All series are written to the global
+// resource of the project.
+func gcmTimeSeries(project, prefix string, m collector.Metric, t time.Time) *monitoringpb.TimeSeries {
+	labels := map[string]string{}
+	for _, l := range m.Labels {
+		if l.Value == "" {
+			continue
+		}
+		if len(labels) == gcmMaxLabels {
+			break
+		}
+		labels[l.Name] = l.Value
+	}
+	kind := metricpb.MetricDescriptor_GAUGE
+	interval := &monitoringpb.TimeInterval{EndTime: timestamppb.New(t)}
+	if m.Counter {
+		kind = metricpb.MetricDescriptor_CUMULATIVE
+		interval.StartTime = timestamppb.New(gcmStartTime)
+	}
+	return &monitoringpb.TimeSeries{
+		Metric: &metricpb.Metric{
+			Type:   prefix + m.Name,
+			Labels: labels,
+		},
+		Resource: &monitoredrespb.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": project},
+		},
+		MetricKind: kind,
+		Points: []*monitoringpb.Point{{
+			Interval: interval,
+			Value:    &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: m.Value}},
+		}},
+	}
+}
+
+// writeGCM writes the metrics collected at time t as Google Cloud Monitoring
+// custom metrics in project, in batches of gcmBatchSize series (the limit of
+// series per request), through client. Batches rejected for exceeding the quota (RESOURCE_EXHAUSTED)
+// are retried up to retries times, with exponential backoff starting at
+// delay. Other failures are not retried, as Cloud Monitoring rejects points
+// older than those already written to a series.
+func writeGCM(ctx context.Context, client *monitoring.MetricClient, project, prefix string, retries int, delay time.Duration, ts []collector.Metric, t time.Time) error {
+	for start := 0; start < len(ts); start += gcmBatchSize {
+		end := start + gcmBatchSize
+		if end > len(ts) {
+			end = len(ts)
+		}
+		series := []*monitoringpb.TimeSeries{}
+		for _, m := range ts[start:end] {
+			series = append(series, gcmTimeSeries(project, prefix, m, t))
+		}
+		req := &monitoringpb.CreateTimeSeriesRequest{
+			Name:       "projects/" + project,
+			TimeSeries: series,
+		}
+		err := retry.Do(ctx, retries, delay, func() error {
+			err := client.CreateTimeSeries(ctx, req)
+			if status.Code(err) != codes.ResourceExhausted {
+				return retry.Permanent(err)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Google Cloud Monitoring support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+)
+
+func TestGCMMetricKind(t *testing.T) {
+	now := time.Now()
+	casetests := []struct {
+		name      string
+		metric    collector.Metric
+		wantKind  metricpb.MetricDescriptor_MetricKind
+		wantStart bool
+	}{
+		{
+			name:      "counter",
+			metric:    collector.Metric{Name: "smartthings_energy_kwh_total", Value: 100, Counter: true},
+			wantKind:  metricpb.MetricDescriptor_CUMULATIVE,
+			wantStart: true,
+		},
+		{
+			name:     "gauge",
+			metric:   collector.Metric{Name: "smartthings_power_watts", Value: 50},
+			wantKind: metricpb.MetricDescriptor_GAUGE,
+		},
+	}
+
+	for _, tt := range casetests {
+		series := gcmTimeSeries("my-project", "custom.googleapis.com/smartthings/", tt.metric, now)
+		if series.MetricKind != tt.wantKind {
+			t.Errorf("%s: metric kind = %v, want %v", tt.name, series.MetricKind, tt.wantKind)
+		}
+		interval := series.Points[0].Interval
+		if !interval.EndTime.AsTime().Equal(now) {
+			t.Errorf("%s: end time = %v, want %v", tt.name, interval.EndTime.AsTime(), now)
+		}
+		if !tt.wantStart {
+			if interval.StartTime != nil {
+				t.Errorf("%s: start time = %v, want none", tt.name, interval.StartTime.AsTime())
+			}
+			continue
+		}
+		if interval.StartTime == nil {
+			t.Errorf("%s: no start time", tt.name)
+			continue
+		}
+		if start := interval.StartTime.AsTime(); !start.Equal(gcmStartTime) || start.After(now) {
+			t.Errorf("%s: start time = %v, want %v", tt.name, start, gcmStartTime)
+		}
+	}
+}
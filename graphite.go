@@ -0,0 +1,98 @@
+// Graphite support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("graphite", func() (sink.Sink, error) {
+		if *flagGraphiteAddr == "" {
+			return nil, nil
+		}
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeGraphite(ctx, *flagGraphiteAddr, *flagGraphitePrefix, ts, t)
+		})), nil
+	})
+}
+
+// graphitePathLabels holds the labels used (in order) to build the path of
+// device metrics in Graphite. Other identifying labels (id and hub) are left
+// out, and labels not listed here are appended to the path.
+var graphitePathLabels = []string{"account", "location", "room", "name"}
+
+// graphiteSkipLabels holds the labels never added to Graphite paths.
+var graphiteSkipLabels = []string{"id", "hub"}
+
+// invalidGraphiteChars matches characters not allowed in Graphite path
+// components.
+var invalidGraphiteChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+
+// graphitePath returns the dotted Graphite path of a metric, e.g.
+// "smartthings.Home.Kitchen.Fridge.smartthings_temperature_fahrenheit".
+// Empty components are skipped, as is the "main" component.
+func graphitePath(prefix string, m collector.Metric) string {
+	parts := []string{}
+	if prefix != "" {
+		parts = append(parts, prefix)
+	}
+	for _, l := range graphitePathLabels {
+		parts = append(parts, m.Label(l))
+	}
+	parts = append(parts, m.Name)
+	for _, l := range m.Labels {
+		if contains(graphitePathLabels, l.Name) || contains(graphiteSkipLabels, l.Name) {
+			continue
+		}
+		if l.Name == "component" && l.Value == "main" {
+			continue
+		}
+		parts = append(parts, l.Value)
+	}
+
+	path := []string{}
+	for _, p := range parts {
+		if p = invalidGraphiteChars.ReplaceAllString(p, "_"); p != "" {
+			path = append(path, p)
+		}
+	}
+	return strings.Join(path, ".")
+}
+
+// writeGraphite sends the metrics collected at time t to a Graphite (Carbon)
+// server at addr (host:port), using the plaintext protocol over TCP. All
+// paths start with prefix.
+func writeGraphite(ctx context.Context, addr, prefix string, ts []collector.Metric, t time.Time) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	for _, m := range ts {
+		fmt.Fprintf(w, "%s %s %d\n", graphitePath(prefix, m), strconv.FormatFloat(m.Value, 'g', -1, 64), t.Unix())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return conn.Close()
+}
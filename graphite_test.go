@@ -0,0 +1,99 @@
+// Graphite support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestGraphitePath(t *testing.T) {
+	casetests := []struct {
+		name   string
+		prefix string
+		metric collector.Metric
+		want   string
+	}{
+		{
+			name:   "device metric",
+			prefix: "smartthings",
+			metric: collector.Metric{
+				Name: "smartthings_temperature_celsius",
+				Labels: []collector.Label{
+					{Name: "id", Value: "d1"},
+					{Name: "name", Value: "Fridge"},
+					{Name: "location", Value: "Home"},
+					{Name: "room", Value: "Kitchen"},
+					{Name: "hub", Value: "Hub"},
+					{Name: "component", Value: "main"},
+				},
+			},
+			want: "smartthings.Home.Kitchen.Fridge.smartthings_temperature_celsius",
+		},
+		{
+			name: "extra labels and invalid characters",
+			metric: collector.Metric{
+				Name: "smartthings_switch_on",
+				Labels: []collector.Label{
+					{Name: "name", Value: "Living room lamp (left)"},
+					{Name: "component", Value: "outlet2"},
+				},
+			},
+			want: "Living_room_lamp_left_.smartthings_switch_on.outlet2",
+		},
+		{
+			name:   "no labels",
+			prefix: "st",
+			metric: collector.Metric{Name: "smartcollector_devices_total"},
+			want:   "st.smartcollector_devices_total",
+		},
+	}
+
+	for _, tt := range casetests {
+		if got := graphitePath(tt.prefix, tt.metric); got != tt.want {
+			t.Errorf("%s: graphitePath = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	ts := []collector.Metric{
+		{Name: "smartthings_power_watts", Labels: []collector.Label{{Name: "name", Value: "Plug"}}, Value: 12.5},
+		{Name: "smartcollector_devices_total", Value: 1},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := writeGraphite(ctx, ln.Addr().String(), "st", ts, time.Unix(1462096800, 0)); err != nil {
+		t.Fatalf("writeGraphite failed: %v", err)
+	}
+
+	want := "st.Plug.smartthings_power_watts 12.5 1462096800\nst.smartcollector_devices_total 1 1462096800\n"
+	if got := <-received; got != want {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
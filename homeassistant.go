@@ -0,0 +1,170 @@
+// Home Assistant support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+// Home Assistant units of measurement, by metric name suffix.
+var homeAssistantUnits = map[string]string{
+	"amperes":    "A",
+	"celsius":    "°C",
+	"dbm":        "dBm",
+	"decibels":   "dB",
+	"fahrenheit": "°F",
+	"kelvin":     "K",
+	"lux":        "lx",
+	"percent":    "%",
+	"ppm":        "ppm",
+	"seconds":    "s",
+	"volts":      "V",
+	"watts":      "W",
+}
+
+// Home Assistant sensor device classes, by SmartThings attribute.
+var homeAssistantDeviceClasses = map[string]string{
+	"battery":       "battery",
+	"carbonDioxide": "carbon_dioxide",
+	"humidity":      "humidity",
+	"illuminance":   "illuminance",
+	"power":         "power",
+	"temperature":   "temperature",
+	"voltage":       "voltage",
+}
+
+// invalidEntityChars matches runs of characters not allowed in Home Assistant
+// entity IDs.
+var invalidEntityChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func init() {
+	sink.Register("homeassistant", func() (sink.Sink, error) {
+		if *flagHomeAssistantURL == "" {
+			return nil, nil
+		}
+		if *flagHomeAssistantToken == "" {
+			return nil, fmt.Errorf("a long-lived access token (--homeassistant-token) is required")
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return postHomeAssistant(ctx, *flagHomeAssistantURL, *flagHomeAssistantToken, *flagMetricPrefix, ts, *flagTimeout)
+		}), nil
+	})
+}
+
+// homeAssistantState is the body of a Home Assistant state update.
+type homeAssistantState struct {
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// entitySlug returns s in lowercase, with runs of invalid characters replaced
+// by underscores.
+func entitySlug(s string) string {
+	return strings.Trim(invalidEntityChars.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
+
+// homeAssistantSensor returns the entity ID and state of the Home Assistant
+// sensor for a device metric. The entity ID is built from the device name,
+// the metric name (without prefix) and the values of labels not identifying
+// the device, except the main component (e.g. sensor.front_door_contact_open or
+// sensor.fridge_temperature_fahrenheit_freezer). The labels are also
+// exported as sensor attributes.
+func homeAssistantSensor(prefix string, m collector.Metric) (string, homeAssistantState) {
+	name := strings.TrimPrefix(m.Name, prefix)
+	parts := []string{m.Label("name"), name}
+	friendly := []string{m.Label("name"), m.Attribute}
+	attrs := map[string]interface{}{}
+
+	// States mapped to numbers (e.g. contact or thermostatMode) are not
+	// measurements, so Home Assistant must not record statistics for them.
+	if _, err := strconv.ParseFloat(m.State, 64); m.State == "" || err == nil {
+		attrs["state_class"] = "measurement"
+	}
+	for _, l := range m.Labels {
+		if l.Value == "" {
+			continue
+		}
+		attrs["smartthings_"+l.Name] = l.Value
+		if !contains(deviceLabelNames, l.Name) && !(l.Name == "component" && l.Value == "main") {
+			parts = append(parts, l.Value)
+			friendly = append(friendly, l.Value)
+		}
+	}
+	attrs["friendly_name"] = strings.Join(friendly, " ")
+	if unit, ok := homeAssistantUnits[name[strings.LastIndex(name, "_")+1:]]; ok {
+		attrs["unit_of_measurement"] = unit
+	}
+	if class, ok := homeAssistantDeviceClasses[m.Attribute]; ok {
+		attrs["device_class"] = class
+	}
+
+	entity := "sensor." + entitySlug(strings.Join(parts, "_"))
+	return entity, homeAssistantState{
+		State:      strconv.FormatFloat(m.Value, 'g', -1, 64),
+		Attributes: attrs,
+	}
+}
+
+// postHomeAssistant creates or updates one Home Assistant sensor for every
+// device attribute metric (metrics not about a device attribute are
+// skipped), through the REST API at baseURL. Each request is limited by
+// timeout.
+func postHomeAssistant(ctx context.Context, baseURL, token, prefix string, ts []collector.Metric, timeout time.Duration) error {
+	for _, m := range ts {
+		if m.Attribute == "" || m.Label("name") == "" {
+			continue
+		}
+		entity, state := homeAssistantSensor(prefix, m)
+		body, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		u := strings.TrimRight(baseURL, "/") + "/api/states/" + entity
+		if err := postHomeAssistantState(ctx, u, token, body, timeout); err != nil {
+			return fmt.Errorf("%s: %v", entity, err)
+		}
+	}
+	return nil
+}
+
+// postHomeAssistantState posts one state update to the Home Assistant URL u.
+func postHomeAssistantState(ctx context.Context, u, token string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Home Assistant returns 201 when the entity is created, and 200 when
+	// updated.
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from home assistant: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
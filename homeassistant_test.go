@@ -0,0 +1,57 @@
+// Home Assistant support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"testing"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestHomeAssistantStateClass(t *testing.T) {
+	casetests := []struct {
+		name  string
+		m     collector.Metric
+		want  bool
+		unit  string
+		class string
+	}{
+		{
+			name:  "numeric",
+			m:     collector.Metric{Name: "smartthings_temperature_celsius", Attribute: "temperature", Value: 21.5},
+			want:  true,
+			unit:  "°C",
+			class: "temperature",
+		},
+		{
+			name: "numeric string",
+			m:    collector.Metric{Name: "smartthings_power_watts", Attribute: "power", Value: 50, State: "50"},
+			want: true,
+			unit: "W",
+		},
+		{
+			name: "enum",
+			m:    collector.Metric{Name: "smartthings_contact_open", Attribute: "contact", Value: 1, State: "open"},
+		},
+		{
+			name: "unknown enum state",
+			m:    collector.Metric{Name: "smartthings_thermostat_mode", Attribute: "thermostatMode", Value: -1, State: "dryair"},
+		},
+	}
+	for _, tt := range casetests {
+		tt.m.Labels = []collector.Label{{Name: "name", Value: "Sensor"}}
+		_, st := homeAssistantSensor("smartthings_", tt.m)
+		if _, got := st.Attributes["state_class"]; got != tt.want {
+			t.Errorf("%s: state_class present = %v, want %v", tt.name, got, tt.want)
+		}
+		if got, _ := st.Attributes["unit_of_measurement"].(string); got != tt.unit {
+			t.Errorf("%s: unit = %q, want %q", tt.name, got, tt.unit)
+		}
+		if got, _ := st.Attributes["device_class"].(string); got != tt.class && tt.class != "" {
+			t.Errorf("%s: device class = %q, want %q", tt.name, got, tt.class)
+		}
+	}
+}
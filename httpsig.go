@@ -0,0 +1,220 @@
+// SmartThings HTTP signature verification for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Base URL of the server holding the certificates used by SmartThings to sign
+// requests. The keyId of each signature is the path of the certificate.
+const smartThingsKeyURL = "https://key.smartthings.com"
+
+// maxClockSkew is how far the Date of a signed request may be from the local
+// time. Older requests are rejected, so captured requests can't be replayed.
+const maxClockSkew = 5 * time.Minute
+
+// signatureVerifier verifies the HTTP signatures (draft-cavage-http-signatures)
+// SmartThings adds to requests sent to webhook SmartApps.
+type signatureVerifier struct {
+	keyURL  string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// newSignatureVerifier returns a signatureVerifier fetching keys from keyURL.
+func newSignatureVerifier(keyURL string, timeout time.Duration) *signatureVerifier {
+	return &signatureVerifier{
+		keyURL:  keyURL,
+		timeout: timeout,
+		keys:    map[string]*rsa.PublicKey{},
+	}
+}
+
+// verify returns nil if r carries a valid rsa-sha256 signature covering the
+// digest of body and a Date within maxClockSkew of the local time.
+func (v *signatureVerifier) verify(r *http.Request, body []byte) error {
+	params, err := parseSignature(r.Header.Get("Authorization"))
+	if err != nil {
+		return err
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" {
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+
+	// The signature only covers the body through the Digest header, and the
+	// request time through the Date header.
+	signed := map[string]bool{}
+	for _, h := range headers {
+		signed[h] = true
+	}
+	if !signed["digest"] {
+		return fmt.Errorf("signature does not cover the request digest")
+	}
+	if !signed["date"] {
+		return fmt.Errorf("signature does not cover the request date")
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("invalid request date: %v", err)
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("request date %s is too far from the local time", date.Format(time.RFC3339))
+	}
+	sum := sha256.Sum256(body)
+	if want := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]); r.Header.Get("Digest") != want {
+		return fmt.Errorf("request digest mismatch")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	key, err := v.key(params["keyId"])
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	return nil
+}
+
+// key returns the public key for keyID, fetching its certificate from the
+// key server the first time it is used.
+func (v *signatureVerifier) key(keyID string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[keyID]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	base, err := url.Parse(v.keyURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(v.keyURL + keyID)
+	if err != nil || !strings.HasPrefix(keyID, "/") || u.Host != base.Host || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid signature key ID %q", keyID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching signature key: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching signature key %q: %s", keyID, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching signature key: %v", err)
+	}
+	if key, err = parsePublicKey(data); err != nil {
+		return nil, fmt.Errorf("error parsing signature key %q: %v", keyID, err)
+	}
+
+	v.mu.Lock()
+	v.keys[keyID] = key
+	v.mu.Unlock()
+	return key, nil
+}
+
+// parsePublicKey returns the RSA public key in a PEM encoded certificate or
+// public key.
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	case "PUBLIC KEY":
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key")
+	}
+	return key, nil
+}
+
+// parseSignature returns the parameters of a "Signature" Authorization header.
+func parseSignature(auth string) (map[string]string, error) {
+	const prefix = "Signature "
+	if !strings.HasPrefix(auth, prefix) {
+		return nil, fmt.Errorf("missing request signature")
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(auth[len(prefix):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid signature parameter %q", p)
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, fmt.Errorf("incomplete request signature")
+	}
+	return params, nil
+}
+
+// signingString returns the string signed for the given (lowercase) headers.
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for n, h := range headers {
+		var val string
+		switch h {
+		case "(request-target)":
+			val = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			val = r.Host
+		default:
+			val = strings.Join(r.Header.Values(h), ", ")
+		}
+		lines[n] = h + ": " + val
+	}
+	return strings.Join(lines, "\n")
+}
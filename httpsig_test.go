@@ -0,0 +1,188 @@
+// SmartThings HTTP signature verification for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSignature(t *testing.T) {
+	casetests := []struct {
+		name    string
+		auth    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			auth: `Signature keyId="/pl/useast2/1a-2b",signature="c2ln",headers="(request-target) digest date",algorithm="rsa-sha256"`,
+			want: map[string]string{
+				"keyId":     "/pl/useast2/1a-2b",
+				"signature": "c2ln",
+				"headers":   "(request-target) digest date",
+				"algorithm": "rsa-sha256",
+			},
+		},
+		{
+			name: "spaces and base64 padding",
+			auth: `Signature keyId="/k", signature="YWJjZA=="`,
+			want: map[string]string{"keyId": "/k", "signature": "YWJjZA=="},
+		},
+		{
+			name:    "not a signature",
+			auth:    "Bearer token",
+			wantErr: true,
+		},
+		{
+			name:    "no key ID",
+			auth:    `Signature signature="c2ln"`,
+			wantErr: true,
+		},
+		{
+			name:    "no signature",
+			auth:    `Signature keyId="/k"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid parameter",
+			auth:    `Signature keyId="/k",signature="c2ln",bogus`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		got, err := parseSignature(tt.auth)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseSignature error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseSignature returned %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	casetests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			name: "certificate",
+			data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		},
+		{
+			name: "public key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}),
+		},
+		{
+			name:    "not an RSA key",
+			data:    pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecPub}),
+			wantErr: true,
+		},
+		{
+			name:    "private key",
+			data:    pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			wantErr: true,
+		},
+		{
+			name:    "not PEM",
+			data:    []byte("not a key"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		got, err := parsePublicKey(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parsePublicKey error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !got.Equal(&key.PublicKey) {
+			t.Errorf("%s: parsePublicKey returned the wrong key", tt.name)
+		}
+	}
+}
+
+func TestSignatureKeyCache(t *testing.T) {
+	signer := newTestSigner(t)
+	defer signer.server.Close()
+
+	// Count the requests made to the key server.
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	handler := signer.server.Config.Handler
+	signer.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	})
+	v := newSignatureVerifier(signer.server.URL, time.Second)
+
+	// Keys are fetched once, failures are not cached, and key IDs must be
+	// paths in the key server.
+	for i := 0; i < 2; i++ {
+		if _, err := v.key("/keys/test"); err != nil {
+			t.Errorf("key failed: %v", err)
+		}
+		if _, err := v.key("/keys/missing"); err == nil {
+			t.Errorf("key returned a missing key")
+		}
+	}
+	for _, id := range []string{"keys/test", "/keys/test?x=1", "@" + httptest.DefaultRemoteAddr + "/keys/test"} {
+		if _, err := v.key(id); err == nil {
+			t.Errorf("key accepted the invalid key ID %q", id)
+		}
+	}
+	want := map[string]int{"/keys/test": 1, "/keys/missing": 2}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(fetches, want) {
+		t.Errorf("key server requests = %v, want %v", fetches, want)
+	}
+}
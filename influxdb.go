@@ -0,0 +1,148 @@
+// InfluxDB v2 support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/retry"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("influxdb", func() (sink.Sink, error) {
+		if *flagInfluxDBURL == "" {
+			return nil, nil
+		}
+		// The timeout applies to each request, with retries.
+		cfg := influxConfig{
+			url:        *flagInfluxDBURL,
+			org:        *flagInfluxDBOrg,
+			bucket:     *flagInfluxDBBucket,
+			token:      *flagInfluxDBToken,
+			batchSize:  *flagInfluxDBBatchSize,
+			timeout:    *flagTimeout,
+			retries:    *flagRetries,
+			retryDelay: *flagRetryDelay,
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeInflux(ctx, cfg, ts, t)
+		}), nil
+	})
+}
+
+// influxConfig holds the settings used to write to InfluxDB.
+type influxConfig struct {
+	url    string
+	org    string
+	bucket string
+	token  string
+
+	// Maximum number of points per request.
+	batchSize int
+
+	// Timeout for each request, and retries of failed requests.
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+}
+
+// Escapers for the InfluxDB line protocol.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// influxLine returns a metric as a line in the InfluxDB line protocol, with
+// the metric name as the measurement, non-empty labels as tags and the value
+// in the "value" field, at time t (in seconds).
+func influxLine(m collector.Metric, t time.Time) string {
+	var b strings.Builder
+	b.WriteString(influxMeasurementEscaper.Replace(m.Name))
+	for _, l := range m.Labels {
+		if l.Value == "" {
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(l.Name), influxTagEscaper.Replace(l.Value))
+	}
+	fmt.Fprintf(&b, " value=%s %d", strconv.FormatFloat(m.Value, 'g', -1, 64), t.Unix())
+	return b.String()
+}
+
+// writeInflux writes the metrics collected at time t to the InfluxDB v2 write
+// API, in batches of at most cfg.batchSize points. Failed batches are retried
+// as configured. Batches written before an error are not rolled back.
+func writeInflux(ctx context.Context, cfg influxConfig, ts []collector.Metric, t time.Time) error {
+	if cfg.bucket == "" {
+		return fmt.Errorf("influxdb bucket cannot be empty")
+	}
+	q := url.Values{}
+	q.Set("org", cfg.org)
+	q.Set("bucket", cfg.bucket)
+	q.Set("precision", "s")
+	u := strings.TrimRight(cfg.url, "/") + "/api/v2/write?" + q.Encode()
+
+	size := cfg.batchSize
+	if size < 1 {
+		size = len(ts)
+	}
+	for start := 0; start < len(ts); start += size {
+		end := start + size
+		if end > len(ts) {
+			end = len(ts)
+		}
+		lines := []string{}
+		for _, m := range ts[start:end] {
+			lines = append(lines, influxLine(m, t))
+		}
+		body := []byte(strings.Join(lines, "\n") + "\n")
+
+		err := retry.Do(ctx, cfg.retries, cfg.retryDelay, func() error {
+			return postInflux(ctx, u, cfg.token, body, cfg.timeout)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postInflux sends one batch of points to the InfluxDB write URL u.
+func postInflux(ctx context.Context, u, token string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// InfluxDB returns 204 on success.
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from influxdb: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
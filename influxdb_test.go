@@ -0,0 +1,157 @@
+// InfluxDB v2 support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestInfluxLine(t *testing.T) {
+	now := time.Unix(1462096800, 0)
+	casetests := []struct {
+		name   string
+		metric collector.Metric
+		want   string
+	}{
+		{
+			name: "labels",
+			metric: collector.Metric{
+				Name:   "smartthings_temperature_celsius",
+				Labels: []collector.Label{{Name: "id", Value: "d1"}, {Name: "hub", Value: ""}},
+				Value:  21.5,
+			},
+			want: "smartthings_temperature_celsius,id=d1 value=21.5 1462096800",
+		},
+		{
+			name: "escaping",
+			metric: collector.Metric{
+				Name:   "a metric,name",
+				Labels: []collector.Label{{Name: "name", Value: "Front door, main=1"}},
+				Value:  1,
+			},
+			want: `a\ metric\,name,name=Front\ door\,\ main\=1 value=1 1462096800`,
+		},
+		{
+			name:   "no labels",
+			metric: collector.Metric{Name: "smartcollector_devices_total", Value: 1e21},
+			want:   "smartcollector_devices_total value=1e+21 1462096800",
+		},
+	}
+
+	for _, tt := range casetests {
+		if got := influxLine(tt.metric, now); got != tt.want {
+			t.Errorf("%s: influxLine = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteInflux(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		fail     int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v2/write" || q.Get("org") != "home" || q.Get("bucket") != "smartthings" || q.Get("precision") != "s" {
+			t.Errorf("unexpected request URL %s", r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q, want %q", got, "Token secret")
+		}
+		if fail > 0 {
+			fail--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ts := []collector.Metric{}
+	for n := 0; n < 5; n++ {
+		ts = append(ts, collector.Metric{Name: fmt.Sprintf("m%d", n), Value: float64(n)})
+	}
+	now := time.Unix(1462096800, 0)
+	cfg := influxConfig{
+		url:        server.URL + "/",
+		org:        "home",
+		bucket:     "smartthings",
+		token:      "secret",
+		timeout:    time.Second,
+		retries:    1,
+		retryDelay: time.Millisecond,
+	}
+
+	casetests := []struct {
+		name      string
+		batchSize int
+		fail      int
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "batches",
+			batchSize: 2,
+			want: []string{
+				"m0 value=0 1462096800\nm1 value=1 1462096800\n",
+				"m2 value=2 1462096800\nm3 value=3 1462096800\n",
+				"m4 value=4 1462096800\n",
+			},
+		},
+		{
+			name: "no batch size",
+			want: []string{"m0 value=0 1462096800\nm1 value=1 1462096800\nm2 value=2 1462096800\nm3 value=3 1462096800\nm4 value=4 1462096800\n"},
+		},
+		{
+			name:      "retried",
+			batchSize: 5,
+			fail:      1,
+			want:      []string{"m0 value=0 1462096800\nm1 value=1 1462096800\nm2 value=2 1462096800\nm3 value=3 1462096800\nm4 value=4 1462096800\n"},
+		},
+		{
+			name:      "retries exhausted",
+			batchSize: 5,
+			fail:      2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range casetests {
+		mu.Lock()
+		requests, fail = nil, tt.fail
+		mu.Unlock()
+
+		cfg.batchSize = tt.batchSize
+		err := writeInflux(context.Background(), cfg, ts, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: writeInflux error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "503") {
+			t.Errorf("%s: error %q does not include the response status", tt.name, err)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(requests, tt.want) {
+			t.Errorf("%s: requests = %q, want %q", tt.name, requests, tt.want)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+// JSON output for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("jsonl", func() (sink.Sink, error) {
+		if *flagJSONLinesFile == "" {
+			return nil, nil
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return appendJSON(*flagJSONLinesFile, ts, t)
+		}), nil
+	})
+}
+
+// jsonRecord is the JSON representation of a metric.
+type jsonRecord struct {
+	Metric    string            `json:"metric"`
+	ID        string            `json:"id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Attribute string            `json:"attribute,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// newJSONRecord returns the JSON record for a metric collected at time t.
+// The device ID, name and attribute are top-level fields. Other non-empty
+// labels go into the labels map.
+func newJSONRecord(m collector.Metric, t time.Time) jsonRecord {
+	rec := jsonRecord{
+		Metric:    m.Name,
+		Attribute: m.Attribute,
+		Value:     m.Value,
+		Timestamp: t,
+	}
+	for _, l := range m.Labels {
+		switch l.Name {
+		case "id":
+			rec.ID = l.Value
+		case "name":
+			rec.Name = l.Value
+		default:
+			if l.Value == "" {
+				continue
+			}
+			if rec.Labels == nil {
+				rec.Labels = map[string]string{}
+			}
+			rec.Labels[l.Name] = l.Value
+		}
+	}
+	return rec
+}
+
+// labelsJSON returns the labels of a record as a JSON object ("{}" if there
+// are none).
+func labelsJSON(rec jsonRecord) (string, error) {
+	if rec.Labels == nil {
+		return "{}", nil
+	}
+	buf, err := json.Marshal(rec.Labels)
+	return string(buf), err
+}
+
+// writeJSON writes one JSON record per line for every metric to w.
+func writeJSON(w io.Writer, ts []collector.Metric, t time.Time) error {
+	enc := json.NewEncoder(w)
+	for _, m := range ts {
+		if err := enc.Encode(newJSONRecord(m, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendJSON appends one JSON record per line for every metric collected at
+// time t to the file fname (or stdout, if fname is "-"), creating the file if
+// needed. Records are written with a single call, so readers tailing the
+// file never see partial collections.
+func appendJSON(fname string, ts []collector.Metric, t time.Time) error {
+	buf := &bytes.Buffer{}
+	if err := writeJSON(buf, ts, t); err != nil {
+		return err
+	}
+	if fname == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
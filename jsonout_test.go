@@ -0,0 +1,75 @@
+// JSON output for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestAppendJSON(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.jsonl")
+	t1 := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	metric := func(value float64) collector.Metric {
+		return collector.Metric{
+			Name:      "smartthings_power_watts",
+			Labels:    []collector.Label{{Name: "id", Value: "p1"}, {Name: "name", Value: "Plug"}, {Name: "room", Value: ""}, {Name: "location", Value: "Home"}},
+			Attribute: "power",
+			Value:     value,
+		}
+	}
+
+	// Every run appends to the file.
+	if err := appendJSON(fname, []collector.Metric{metric(10), {Name: "smartcollector_devices_total", Value: 1}}, t1); err != nil {
+		t.Fatalf("appendJSON failed: %v", err)
+	}
+	if err := appendJSON(fname, []collector.Metric{metric(12.5)}, t2); err != nil {
+		t.Fatalf("appendJSON failed: %v", err)
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := []jsonRecord{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec jsonRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, rec)
+	}
+
+	device := func(value float64, ts time.Time) jsonRecord {
+		return jsonRecord{
+			Metric:    "smartthings_power_watts",
+			ID:        "p1",
+			Name:      "Plug",
+			Attribute: "power",
+			Labels:    map[string]string{"location": "Home"},
+			Value:     value,
+			Timestamp: ts,
+		}
+	}
+	want := []jsonRecord{
+		device(10, t1),
+		{Metric: "smartcollector_devices_total", Value: 1, Timestamp: t1},
+		device(12.5, t2),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %+v, want %+v", got, want)
+	}
+}
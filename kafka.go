@@ -0,0 +1,66 @@
+// Kafka support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("kafka", func() (sink.Sink, error) {
+		if *flagKafkaBrokers == "" {
+			return nil, nil
+		}
+		w := newKafkaWriter(*flagKafkaBrokers, *flagKafkaTopic)
+		return withClose(withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeKafka(ctx, w, ts, t)
+		})), w.Close), nil
+	})
+}
+
+// kafkaMessages returns one Kafka message per metric collected at time t,
+// holding the same JSON record written by --output=json. Messages are keyed
+// by device ID, so readings from the same device go to the same partition
+// (and keep their order.)
+func kafkaMessages(ts []collector.Metric, t time.Time) ([]kafka.Message, error) {
+	msgs := []kafka.Message{}
+	for _, m := range ts {
+		rec := newJSONRecord(m, t)
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(rec.ID), Value: value, Time: t})
+	}
+	return msgs, nil
+}
+
+// newKafkaWriter returns a writer publishing to a Kafka topic. Brokers is a
+// comma separated list of host:port addresses.
+func newKafkaWriter(brokers, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+}
+
+// writeKafka publishes the metrics collected at time t with w.
+func writeKafka(ctx context.Context, w *kafka.Writer, ts []collector.Metric, t time.Time) error {
+	msgs, err := kafkaMessages(ts, t)
+	if err != nil {
+		return err
+	}
+	return w.WriteMessages(ctx, msgs...)
+}
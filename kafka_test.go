@@ -0,0 +1,76 @@
+// Kafka support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+func TestKafkaMessages(t *testing.T) {
+	ts := []collector.Metric{
+		{
+			Name:      "smartthings_temperature_celsius",
+			Labels:    []collector.Label{{Name: "id", Value: "d1"}, {Name: "name", Value: "Kitchen"}, {Name: "room", Value: "Kitchen"}, {Name: "hub", Value: ""}},
+			Attribute: "temperature",
+			Value:     21.5,
+		},
+		{
+			Name:  "smartcollector_devices_total",
+			Value: 1,
+		},
+	}
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	msgs, err := kafkaMessages(ts, now)
+	if err != nil {
+		t.Fatalf("kafkaMessages failed: %v", err)
+	}
+	want := []struct {
+		key string
+		rec jsonRecord
+	}{
+		{
+			key: "d1",
+			rec: jsonRecord{
+				Metric:    "smartthings_temperature_celsius",
+				ID:        "d1",
+				Name:      "Kitchen",
+				Attribute: "temperature",
+				Labels:    map[string]string{"room": "Kitchen"},
+				Value:     21.5,
+				Timestamp: now,
+			},
+		},
+		{
+			key: "",
+			rec: jsonRecord{Metric: "smartcollector_devices_total", Value: 1, Timestamp: now},
+		},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for n, msg := range msgs {
+		if got := string(msg.Key); got != want[n].key {
+			t.Errorf("message %d: key = %q, want %q", n, got, want[n].key)
+		}
+		if !msg.Time.Equal(now) {
+			t.Errorf("message %d: time = %v, want %v", n, msg.Time, now)
+		}
+		var rec jsonRecord
+		if err := json.Unmarshal(msg.Value, &rec); err != nil {
+			t.Errorf("message %d: invalid JSON value %q: %v", n, msg.Value, err)
+			continue
+		}
+		if !reflect.DeepEqual(rec, want[n].rec) {
+			t.Errorf("message %d: value = %+v, want %+v", n, rec, want[n].rec)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// NATS support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/net/context"
+)
+
+// natsTokenEscaper replaces characters with special meaning in NATS subjects.
+var natsTokenEscaper = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "*", "_", ">", "_")
+
+func init() {
+	sink.Register("nats", func() (sink.Sink, error) {
+		if *flagNATSURL == "" {
+			return nil, nil
+		}
+		// The connection is kept for all collections, reconnecting in the
+		// background as needed (including when no server is up yet.)
+		nc, err := nats.Connect(*flagNATSURL, nats.Name("smartcollector"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, err
+		}
+		return withClose(withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return publishNATS(ctx, nc, *flagNATSSubjectPrefix, ts, t)
+		})), func() error {
+			nc.Close()
+			return nil
+		}), nil
+	})
+}
+
+// natsToken returns s as a valid NATS subject token ("_" if empty.)
+func natsToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return natsTokenEscaper.Replace(s)
+}
+
+// natsSubject returns the subject for a metric: the prefix followed by the
+// location, device name and attribute (or metric name, for metrics not about
+// an attribute), e.g. smartthings.Home.Front_door.contact.
+func natsSubject(prefix string, m collector.Metric) string {
+	attr := m.Attribute
+	if attr == "" {
+		attr = m.Name
+	}
+	return prefix + "." + natsToken(m.Label("location")) + "." + natsToken(m.Label("name")) + "." + natsToken(attr)
+}
+
+// publishNATS publishes the metrics collected at time t through nc, one
+// message per metric holding the same JSON record written by --output=json.
+// Publishing is fire and forget, but all messages are flushed to the server
+// before returning.
+func publishNATS(ctx context.Context, nc *nats.Conn, prefix string, ts []collector.Metric, t time.Time) error {
+	for _, m := range ts {
+		data, err := json.Marshal(newJSONRecord(m, t))
+		if err != nil {
+			return err
+		}
+		if err := nc.Publish(natsSubject(prefix, m), data); err != nil {
+			return err
+		}
+	}
+	return nc.FlushWithContext(ctx)
+}
@@ -0,0 +1,164 @@
+// NATS support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/net/context"
+)
+
+func TestNATSSubject(t *testing.T) {
+	casetests := []struct {
+		name   string
+		metric collector.Metric
+		want   string
+	}{
+		{
+			name: "attribute",
+			metric: collector.Metric{
+				Name:      "smartthings_contact_open",
+				Labels:    []collector.Label{{Name: "location", Value: "Home"}, {Name: "name", Value: "Front door"}},
+				Attribute: "contact",
+			},
+			want: "smartthings.Home.Front_door.contact",
+		},
+		{
+			name: "special characters",
+			metric: collector.Metric{
+				Name:      "smartthings_power_watts",
+				Labels:    []collector.Label{{Name: "location", Value: "my.home"}, {Name: "name", Value: "Plug*>"}},
+				Attribute: "power",
+			},
+			want: "smartthings.my_home.Plug__.power",
+		},
+		{
+			name:   "not a device metric",
+			metric: collector.Metric{Name: "smartcollector_devices_total"},
+			want:   "smartthings._._.smartcollector_devices_total",
+		},
+	}
+
+	for _, tt := range casetests {
+		if got := natsSubject("smartthings", tt.metric); got != tt.want {
+			t.Errorf("%s: natsSubject = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeNATS is a minimal NATS server recording the messages published to it.
+type fakeNATS struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	subjects []string
+	payloads [][]byte
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATS{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	io.WriteString(conn, `INFO {"server_id":"fake","version":"2.10.0","proto":1,"max_payload":1048576}`+"\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.subjects = append(s.subjects, fields[1])
+			s.payloads = append(s.payloads, payload[:size])
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestPublishNATS(t *testing.T) {
+	server := newFakeNATS(t)
+	defer server.ln.Close()
+	nc, err := nats.Connect("nats://" + server.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error connecting to the fake server: %v", err)
+	}
+	defer nc.Close()
+
+	ts := []collector.Metric{
+		{
+			Name:      "smartthings_contact_open",
+			Labels:    []collector.Label{{Name: "id", Value: "c1"}, {Name: "location", Value: "Home"}, {Name: "name", Value: "Front door"}},
+			Attribute: "contact",
+			Value:     1,
+		},
+		{Name: "smartcollector_devices_total", Value: 1},
+	}
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := publishNATS(ctx, nc, "st", ts, now); err != nil {
+		t.Fatalf("publishNATS failed: %v", err)
+	}
+
+	// All messages are received by the server once publishNATS returns.
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	wantSubjects := []string{"st.Home.Front_door.contact", "st._._.smartcollector_devices_total"}
+	if len(server.subjects) != len(wantSubjects) {
+		t.Fatalf("got subjects %q, want %q", server.subjects, wantSubjects)
+	}
+	for n, subject := range server.subjects {
+		if subject != wantSubjects[n] {
+			t.Errorf("message %d: subject = %q, want %q", n, subject, wantSubjects[n])
+		}
+		var rec jsonRecord
+		if err := json.Unmarshal(server.payloads[n], &rec); err != nil {
+			t.Errorf("message %d: invalid payload %q: %v", n, server.payloads[n], err)
+			continue
+		}
+		if rec.Metric != ts[n].Name || rec.Value != ts[n].Value || !rec.Timestamp.Equal(now) {
+			t.Errorf("message %d: payload = %+v, want the record of %s", n, rec, ts[n].Name)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Change notifications for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+// File holding the state of the notification conditions between runs.
+const notifyStateFile = tokenFilePrefix + "_notify.json"
+
+// notifyRules holds the notification rules from the configuration file. The
+// rules are replaced when the configuration is reloaded.
+var notifyRules struct {
+	sync.Mutex
+	rules []notifyRule
+}
+
+// setNotifyRules replaces the notification rules.
+func setNotifyRules(rules []notifyRule) {
+	notifyRules.Lock()
+	defer notifyRules.Unlock()
+	notifyRules.rules = rules
+}
+
+// getNotifyRules returns the current notification rules.
+func getNotifyRules() []notifyRule {
+	notifyRules.Lock()
+	defer notifyRules.Unlock()
+	return notifyRules.rules
+}
+
+// notifyRule is a condition on an attribute of the devices matching the
+// device patterns (all devices, if empty.) Exactly one of the conditions
+// must be set: equals and not_equals compare the attribute state (e.g.,
+// "wet"), and above and below compare the numeric value of the metric.
+type notifyRule struct {
+	Attribute string   `yaml:"attribute"`
+	Devices   []string `yaml:"devices"`
+	Equals    *string  `yaml:"equals"`
+	NotEquals *string  `yaml:"not_equals"`
+	Above     *float64 `yaml:"above"`
+	Below     *float64 `yaml:"below"`
+}
+
+// validate checks the rule for errors.
+func (r *notifyRule) validate() error {
+	if r.Attribute == "" {
+		return fmt.Errorf("attribute is required")
+	}
+	for _, pattern := range r.Devices {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	n := 0
+	for _, set := range []bool{r.Equals != nil, r.NotEquals != nil, r.Above != nil, r.Below != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one of equals, not_equals, above or below is required")
+	}
+	return nil
+}
+
+// String returns the rule condition in human readable form (e.g.,
+// "smoke != clear").
+func (r *notifyRule) String() string {
+	switch {
+	case r.Equals != nil:
+		return r.Attribute + " == " + *r.Equals
+	case r.NotEquals != nil:
+		return r.Attribute + " != " + *r.NotEquals
+	case r.Above != nil:
+		return r.Attribute + " > " + strconv.FormatFloat(*r.Above, 'g', -1, 64)
+	}
+	return r.Attribute + " < " + strconv.FormatFloat(*r.Below, 'g', -1, 64)
+}
+
+// applies returns true if the rule is about the attribute and device of m.
+// State comparisons only apply to metrics with a state.
+func (r *notifyRule) applies(m collector.Metric) bool {
+	if m.Attribute != r.Attribute {
+		return false
+	}
+	if (r.Equals != nil || r.NotEquals != nil) && m.State == "" {
+		return false
+	}
+	return len(r.Devices) == 0 || matchAny(r.Devices, m.Label("id"), m.Label("name"))
+}
+
+// active returns true if the rule condition holds for m.
+func (r *notifyRule) active(m collector.Metric) bool {
+	switch {
+	case r.Equals != nil:
+		return m.State == *r.Equals
+	case r.NotEquals != nil:
+		return m.State != *r.NotEquals
+	case r.Above != nil:
+		return m.Value > *r.Above
+	}
+	return m.Value < *r.Below
+}
+
+// notifyEvent is the JSON payload posted when a condition changes.
+type notifyEvent struct {
+	Condition string    `json:"condition"`
+	Active    bool      `json:"active"`
+	DeviceID  string    `json:"device_id"`
+	Device    string    `json:"device"`
+	Location  string    `json:"location,omitempty"`
+	Room      string    `json:"room,omitempty"`
+	Component string    `json:"component,omitempty"`
+	Attribute string    `json:"attribute"`
+	State     string    `json:"state,omitempty"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func init() {
+	sink.Register("notify", func() (sink.Sink, error) {
+		if *flagNotifyURL == "" {
+			return nil, nil
+		}
+		if len(getNotifyRules()) == 0 {
+			return nil, fmt.Errorf("no notification rules (notify) in the configuration file")
+		}
+		return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return notifyChanges(ctx, *flagNotifyURL, getNotifyRules(), notifyStateFile, ts, t)
+		}), nil
+	})
+}
+
+// notifyKey returns the key identifying the state of a rule condition for the
+// device attribute in m. Attributes exported as several metrics (e.g., one
+// per state) share the same key.
+func notifyKey(r *notifyRule, m collector.Metric) string {
+	return strings.Join([]string{r.String(), m.Label("account"), m.Label("id"), m.Label("component"), m.Attribute}, "/")
+}
+
+// notifyChanges posts an event to url for every device attribute whose rule
+// condition changed since the last run, including conditions becoming
+// inactive again. Conditions active the first time an attribute is seen
+// are also notified. The condition states are kept in stateFile; the state
+// of events that could not be posted is not updated, so they are retried on
+// the next run.
+func notifyChanges(ctx context.Context, url string, rules []notifyRule, stateFile string, ts []collector.Metric, t time.Time) error {
+	state := map[string]bool{}
+	if err := loadStateFile(stateFile, &state); err != nil {
+		slog.Warn("Ignoring invalid notification state", "file", stateFile, "error", err)
+		state = map[string]bool{}
+	}
+
+	var errs []string
+	seen := map[string]bool{}
+	for n := range rules {
+		r := &rules[n]
+		for _, m := range ts {
+			if !r.applies(m) {
+				continue
+			}
+			key := notifyKey(r, m)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			active := r.active(m)
+			prev, ok := state[key]
+			if active == prev && (ok || !active) {
+				state[key] = active
+				continue
+			}
+			ev := notifyEvent{
+				Condition: r.String(),
+				Active:    active,
+				DeviceID:  m.Label("id"),
+				Device:    m.Label("name"),
+				Location:  m.Label("location"),
+				Room:      m.Label("room"),
+				Component: m.Label("component"),
+				Attribute: m.Attribute,
+				State:     m.State,
+				Value:     m.Value,
+				Timestamp: t,
+			}
+			if err := postNotification(ctx, url, ev); err != nil {
+				errs = append(errs, fmt.Sprintf("%s (%s): %v", ev.Device, ev.Condition, err))
+				continue
+			}
+			slog.Info("Notification sent", "device", ev.Device, "condition", ev.Condition, "active", active)
+			state[key] = active
+		}
+	}
+
+	// Forget devices and attributes no longer present.
+	for key := range state {
+		if !seen[key] {
+			delete(state, key)
+		}
+	}
+	if err := saveStateFile(stateFile, state); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// loadStateFile reads the JSON state in fname into v. A missing file is not
+// an error, and leaves v untouched.
+func loadStateFile(fname string, v interface{}) error {
+	buf, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// saveStateFile writes v as JSON to fname atomically.
+func saveStateFile(fname string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tempfile := fname + ".tmp"
+	if err := ioutil.WriteFile(tempfile, buf, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tempfile, fname); err != nil {
+		os.Remove(tempfile)
+		return err
+	}
+	return nil
+}
+
+// postNotification posts one event to url, limited by --timeout.
+func postNotification(ctx context.Context, url string, ev notifyEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, *flagTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Change notifications for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestNotifyChanges(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+		fail   bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var ev notifyEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("invalid notification: %v", err)
+			return
+		}
+		events = append(events, fmt.Sprintf("%s:%s:%v", ev.DeviceID, ev.Condition, ev.Active))
+	}))
+	defer server.Close()
+
+	wet, hot := "wet", 30.0
+	rules := []notifyRule{
+		{Attribute: "water", Equals: &wet},
+		{Attribute: "temperature", Devices: []string{"Attic*"}, Above: &hot},
+	}
+	leak := func(state string) collector.Metric {
+		value := 0.0
+		if state == "wet" {
+			value = 1
+		}
+		return collector.Metric{
+			Name:      "smartthings_water_wet",
+			Labels:    []collector.Label{{Name: "id", Value: "w1"}, {Name: "name", Value: "Basement leak"}},
+			Attribute: "water",
+			State:     state,
+			Value:     value,
+		}
+	}
+	temp := func(name string, value float64) collector.Metric {
+		return collector.Metric{
+			Name:      "smartthings_temperature_celsius",
+			Labels:    []collector.Label{{Name: "id", Value: name}, {Name: "name", Value: name}},
+			Attribute: "temperature",
+			Value:     value,
+		}
+	}
+
+	// Runs share the same state file, in order.
+	casetests := []struct {
+		name    string
+		ts      []collector.Metric
+		fail    bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "first run, inactive",
+			ts:   []collector.Metric{leak("dry"), temp("Attic", 25), temp("Oven", 200)},
+		},
+		{
+			name: "condition becomes active",
+			ts:   []collector.Metric{leak("wet"), temp("Attic", 25), temp("Oven", 200)},
+			want: []string{"w1:water == wet:true"},
+		},
+		{
+			name: "no change",
+			ts:   []collector.Metric{leak("wet"), temp("Attic", 25)},
+		},
+		{
+			name:    "post fails",
+			ts:      []collector.Metric{leak("dry"), temp("Attic", 31)},
+			fail:    true,
+			wantErr: true,
+		},
+		{
+			name: "failed events are retried",
+			ts:   []collector.Metric{leak("dry"), temp("Attic", 31)},
+			want: []string{"w1:water == wet:false", "Attic:temperature > 30:true"},
+		},
+		{
+			name: "device removed",
+			ts:   []collector.Metric{leak("dry")},
+		},
+		{
+			name: "device back, active",
+			ts:   []collector.Metric{leak("dry"), temp("Attic", 35)},
+			want: []string{"Attic:temperature > 30:true"},
+		},
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "notify.json")
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, tt := range casetests {
+		mu.Lock()
+		events, fail = nil, tt.fail
+		mu.Unlock()
+
+		err := notifyChanges(context.Background(), server.URL, rules, stateFile, tt.ts, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: notifyChanges error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(events, tt.want) {
+			t.Errorf("%s: events = %v, want %v", tt.name, events, tt.want)
+		}
+		mu.Unlock()
+	}
+}
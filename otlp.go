@@ -0,0 +1,209 @@
+// OpenTelemetry (OTLP) support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+func init() {
+	sink.Register("otlp", func() (sink.Sink, error) {
+		if *flagOTLPEndpoint == "" {
+			return nil, nil
+		}
+		e, err := newOTLPExporter(*flagOTLPProtocol, *flagOTLPEndpoint, *flagOTLPInsecure)
+		if err != nil {
+			return nil, err
+		}
+		return withClose(withTimeout(metricsSink(e.export)), e.Close), nil
+	})
+}
+
+// otlpResourceLabels maps the labels identifying a device to OpenTelemetry
+// resource attributes. All other labels become data point attributes.
+var otlpResourceLabels = map[string]string{
+	"id":       "device.id",
+	"name":     "device.name",
+	"location": "smartthings.location",
+	"room":     "smartthings.room",
+	"hub":      "smartthings.hub",
+	"account":  "smartthings.account",
+}
+
+// otlpStartTime is the start time of cumulative sums (counters). We don't know
+// when device counters (e.g. energy meters) were last reset, so the process
+// start time is used for all of them.
+var otlpStartTime = time.Now()
+
+// otlpRequest returns an OTLP export request with the metrics collected at
+// time t. Metrics are grouped in one resource per device, with the device
+// labels as resource attributes. Metrics not about a device (e.g., about the
+// collection itself) go into a resource without device attributes.
+func otlpRequest(ts []collector.Metric, t time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	now := uint64(t.UnixNano())
+	start := uint64(otlpStartTime.UnixNano())
+
+	// Resources and metrics within each resource, in order of first
+	// appearance.
+	resources := []*metricspb.ResourceMetrics{}
+	byDevice := map[string]*metricspb.ScopeMetrics{}
+	byName := map[*metricspb.ScopeMetrics]map[string]*metricspb.Metric{}
+
+	for _, m := range ts {
+		key := m.Label("account") + "/" + m.Label("id")
+		sm, ok := byDevice[key]
+		if !ok {
+			attrs := []*commonpb.KeyValue{otlpString("service.name", "smartcollector")}
+			for _, l := range m.Labels {
+				if name, ok := otlpResourceLabels[l.Name]; ok && l.Value != "" {
+					attrs = append(attrs, otlpString(name, l.Value))
+				}
+			}
+			sm = &metricspb.ScopeMetrics{
+				Scope: &commonpb.InstrumentationScope{Name: "github.com/marcopaganini/smartcollector"},
+			}
+			resources = append(resources, &metricspb.ResourceMetrics{
+				Resource:     &resourcepb.Resource{Attributes: attrs},
+				ScopeMetrics: []*metricspb.ScopeMetrics{sm},
+			})
+			byDevice[key] = sm
+			byName[sm] = map[string]*metricspb.Metric{}
+		}
+
+		attrs := []*commonpb.KeyValue{}
+		for _, l := range m.Labels {
+			if _, ok := otlpResourceLabels[l.Name]; !ok && l.Value != "" {
+				attrs = append(attrs, otlpString(l.Name, l.Value))
+			}
+		}
+		dp := &metricspb.NumberDataPoint{
+			Attributes:   attrs,
+			TimeUnixNano: now,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: m.Value},
+		}
+
+		om, ok := byName[sm][m.Name]
+		if !ok {
+			om = &metricspb.Metric{Name: m.Name, Description: m.Help}
+			if m.Counter {
+				om.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+				}}
+			} else {
+				om.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+			}
+			sm.Metrics = append(sm.Metrics, om)
+			byName[sm][m.Name] = om
+		}
+		switch data := om.Data.(type) {
+		case *metricspb.Metric_Sum:
+			dp.StartTimeUnixNano = start
+			data.Sum.DataPoints = append(data.Sum.DataPoints, dp)
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, dp)
+		}
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: resources}
+}
+
+// otlpString returns a string attribute.
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// otlpExporter sends metrics to an OpenTelemetry Collector (or any other OTLP
+// receiver.)
+type otlpExporter struct {
+	protocol string
+	endpoint string
+
+	// Client connection, with protocol "grpc".
+	conn *grpc.ClientConn
+}
+
+// newOTLPExporter returns an exporter for the given protocol and endpoint.
+// With protocol "grpc", endpoint is a host:port address, using TLS unless
+// insecure is set. With "http", endpoint is the base URL of the receiver
+// (e.g. http://collector:4318), and metrics are posted to /v1/metrics. The
+// gRPC connection is established when first used, and kept until Close.
+func newOTLPExporter(protocol, endpoint string, insecureConn bool) (*otlpExporter, error) {
+	e := &otlpExporter{protocol: protocol, endpoint: endpoint}
+	switch protocol {
+	case "grpc":
+		creds := credentials.NewTLS(nil)
+		if insecureConn {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		e.conn = conn
+	case "http":
+	default:
+		return nil, fmt.Errorf("invalid OTLP protocol %q (valid protocols are grpc and http)", protocol)
+	}
+	return e, nil
+}
+
+// Close closes the gRPC connection, if any.
+func (e *otlpExporter) Close() error {
+	if e.conn == nil {
+		return nil
+	}
+	return e.conn.Close()
+}
+
+// export sends the metrics collected at time t.
+func (e *otlpExporter) export(ctx context.Context, ts []collector.Metric, t time.Time) error {
+	req := otlpRequest(ts, t)
+	if e.conn != nil {
+		_, err := colmetricspb.NewMetricsServiceClient(e.conn).Export(ctx, req)
+		return err
+	}
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimRight(e.endpoint, "/") + "/v1/metrics"
+	hreq, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := http.DefaultClient.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
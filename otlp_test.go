@@ -0,0 +1,137 @@
+// OpenTelemetry (OTLP) support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPStartTime(t *testing.T) {
+	ts := []collector.Metric{
+		{Name: "smartthings_energy_kwh_total", Value: 100, Counter: true},
+		{Name: "smartthings_power_watts", Value: 50},
+	}
+	now := time.Now()
+	req := otlpRequest(ts, now)
+
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(metrics))
+	}
+	sum := metrics[0].GetSum()
+	if sum == nil {
+		t.Fatalf("%s is not a sum", metrics[0].Name)
+	}
+	dp := sum.DataPoints[0]
+	if got, want := dp.StartTimeUnixNano, uint64(otlpStartTime.UnixNano()); got != want || got == 0 {
+		t.Errorf("counter start time = %d, want %d", got, want)
+	}
+	if dp.StartTimeUnixNano > dp.TimeUnixNano {
+		t.Errorf("counter start time %d is after the point time %d", dp.StartTimeUnixNano, dp.TimeUnixNano)
+	}
+	if got := metrics[1].GetGauge().DataPoints[0].StartTimeUnixNano; got != 0 {
+		t.Errorf("gauge start time = %d, want 0", got)
+	}
+}
+
+func TestOTLPRequestResources(t *testing.T) {
+	device := func(id, name string) []collector.Label {
+		return []collector.Label{{Name: "id", Value: id}, {Name: "name", Value: name}, {Name: "room", Value: ""}, {Name: "component", Value: "main"}}
+	}
+	ts := []collector.Metric{
+		{Name: "smartthings_temperature_celsius", Labels: device("d1", "Fridge"), Value: 4},
+		{Name: "smartthings_power_watts", Labels: device("d2", "Plug"), Value: 12},
+		{Name: "smartthings_temperature_celsius", Labels: device("d2", "Plug"), Value: 30},
+		{Name: "smartcollector_devices_total", Value: 2},
+	}
+	req := otlpRequest(ts, time.Now())
+
+	// One resource per device (and one for metrics not about a device),
+	// holding the device labels as attributes and the metric names.
+	got := [][]string{}
+	for _, rm := range req.ResourceMetrics {
+		res := []string{}
+		for _, kv := range rm.Resource.Attributes {
+			res = append(res, kv.Key+"="+kv.Value.GetStringValue())
+		}
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			dp := m.GetGauge().DataPoints[0]
+			for _, kv := range dp.Attributes {
+				res = append(res, m.Name+"{"+kv.Key+"="+kv.Value.GetStringValue()+"}")
+			}
+		}
+		got = append(got, res)
+	}
+	want := [][]string{
+		{"service.name=smartcollector", "device.id=d1", "device.name=Fridge", "smartthings_temperature_celsius{component=main}"},
+		{"service.name=smartcollector", "device.id=d2", "device.name=Plug", "smartthings_power_watts{component=main}", "smartthings_temperature_celsius{component=main}"},
+		{"service.name=smartcollector"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %q, want %q", got, want)
+	}
+}
+
+func TestOTLPExportHTTP(t *testing.T) {
+	var (
+		gotPath        string
+		gotContentType string
+		got            colmetricspb.ExportMetricsServiceRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotContentType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	e, err := newOTLPExporter("http", server.URL+"/", false)
+	if err != nil {
+		t.Fatalf("newOTLPExporter failed: %v", err)
+	}
+	defer e.Close()
+	ts := []collector.Metric{
+		{Name: "smartthings_energy_kwh_total", Labels: []collector.Label{{Name: "id", Value: "p1"}}, Value: 100, Counter: true},
+		{Name: "smartthings_power_watts", Labels: []collector.Label{{Name: "id", Value: "p1"}}, Value: 50},
+	}
+	now := time.Now()
+	if err := e.export(context.Background(), ts, now); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	if gotPath != "/v1/metrics" {
+		t.Errorf("path = %q, want /v1/metrics", gotPath)
+	}
+	if gotContentType != "application/x-protobuf" {
+		t.Errorf("Content-Type = %q, want application/x-protobuf", gotContentType)
+	}
+	if want := otlpRequest(ts, now); !proto.Equal(&got, want) {
+		t.Errorf("received request %v, want %v", &got, want)
+	}
+
+	// Errors from the receiver are reported.
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer bad.Close()
+	e, _ = newOTLPExporter("http", bad.URL, false)
+	if err := e.export(context.Background(), ts, now); err == nil {
+		t.Errorf("export ignored an error from the receiver")
+	}
+}
@@ -0,0 +1,220 @@
+// Output handling for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+// deviceLabelNames holds the labels identifying the device of a metric, as
+// opposed to labels further describing the value (like component or unit.)
+var deviceLabelNames = []string{"id", "name", "location", "room", "hub", "account"}
+
+func init() {
+	sink.Register("textfile", func() (sink.Sink, error) {
+		if !setFlags()["textfile-dir"] && *flagTextFileCollectorDir == textFileCollectorDir {
+			return nil, nil
+		}
+		return textfileSink(), nil
+	})
+}
+
+// textfileSink returns a sink writing to the textfile collector directory.
+func textfileSink() sink.Sink {
+	return metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+		return saveTimeSeries(filepath.Join(*flagTextFileCollectorDir, textFileCollectorName), ts)
+	})
+}
+
+// metricsSink returns a sink calling fn with the metrics and collection time
+// of the samples.
+func metricsSink(fn func(ctx context.Context, ts []collector.Metric, t time.Time) error) sink.Sink {
+	return sink.Func(func(ctx context.Context, samples []sink.Sample) error {
+		ts, t := sink.Metrics(samples)
+		return fn(ctx, ts, t)
+	})
+}
+
+// withTimeout returns a sink calling s with a context limited to the
+// --timeout flag.
+func withTimeout(s sink.Sink) sink.Sink {
+	return sink.Func(func(ctx context.Context, samples []sink.Sample) error {
+		ctx, cancel := context.WithTimeout(ctx, *flagTimeout)
+		defer cancel()
+		return s.Write(ctx, samples)
+	})
+}
+
+// closerSink is a sink releasing its resources with close.
+type closerSink struct {
+	sink.Sink
+	close func() error
+}
+
+// Close implements io.Closer.
+func (s closerSink) Close() error {
+	return s.close()
+}
+
+// withClose returns s with a Close method calling close, for sinks holding
+// resources (e.g., connections or clients) reused across collections.
+func withClose(s sink.Sink, close func() error) sink.Sink {
+	return closerSink{Sink: s, close: close}
+}
+
+// closeSinks closes all sinks implementing io.Closer, logging any errors.
+func closeSinks(sinks map[string]sink.Sink) {
+	for name, s := range sinks {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Error("Error closing output", "output", name, "error", err)
+			}
+		}
+	}
+}
+
+// auxiliarySinks holds the sinks that complement the main outputs (e.g., by
+// reacting to changes), and don't replace the textfile collector file.
+var auxiliarySinks = []string{"archive", "events", "notify"}
+
+// openSinks returns all sinks enabled in the command line (or environment and
+// configuration file), by name. The textfile collector file is written when
+// no other sink (except auxiliary sinks) is enabled, or when its directory is
+// set explicitly. Sinks are meant to be opened once, reused for every
+// collection, and closed with closeSinks.
+func openSinks() (map[string]sink.Sink, error) {
+	sinks, err := sink.Open()
+	if err != nil {
+		return nil, err
+	}
+	for name := range sinks {
+		if !contains(auxiliarySinks, name) {
+			return sinks, nil
+		}
+	}
+	sinks["textfile"] = textfileSink()
+	return sinks, nil
+}
+
+// auxiliaryWriter writes the metrics of every collection to the auxiliary
+// sinks in serve mode, where Prometheus scrapes replace the other outputs.
+// Auxiliary sinks keep state between writes (e.g., the last state of each
+// device), so collections are written one at a time, from a single
+// goroutine, and in order: collections older than the last one queued are
+// dropped, as is a queued collection replaced by a newer one before being
+// written.
+type auxiliaryWriter struct {
+	sinks map[string]sink.Sink
+
+	mu      sync.Mutex
+	pending []sink.Sample
+	last    time.Time
+	closed  bool
+
+	ready chan struct{}
+	done  chan struct{}
+}
+
+// newAuxiliaryWriter returns an auxiliaryWriter for sinks (opened with
+// sink.Open(auxiliarySinks...)), and starts its goroutine.
+func newAuxiliaryWriter(sinks map[string]sink.Sink) *auxiliaryWriter {
+	w := &auxiliaryWriter{
+		sinks: sinks,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write queues the metrics collected at time t to be written.
+func (w *auxiliaryWriter) write(ts []collector.Metric, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || !t.After(w.last) {
+		slog.Debug("Skipping outdated collection for auxiliary outputs", "time", t)
+		return
+	}
+	w.last = t
+	w.pending = sink.Samples(ts, t)
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queued collections until the writer is closed.
+func (w *auxiliaryWriter) run() {
+	defer close(w.done)
+	for range w.ready {
+		w.mu.Lock()
+		samples := w.pending
+		w.pending = nil
+		w.mu.Unlock()
+		if samples == nil {
+			continue
+		}
+		if err := writeSinks(context.Background(), w.sinks, samples); err != nil {
+			slog.Error("Error writing auxiliary outputs", "error", err)
+		}
+	}
+}
+
+// close writes any queued collection and stops the writer. Collections
+// queued afterwards are dropped.
+func (w *auxiliaryWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ready)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// writeSinks writes the samples to all sinks concurrently. Sinks are
+// independent: a failing (or slow) sink does not keep the others from being
+// written. Failures are logged, and an error is returned if any sink failed.
+func writeSinks(ctx context.Context, sinks map[string]sink.Sink, samples []sink.Sample) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for name, s := range sinks {
+		wg.Add(1)
+		go func(name string, s sink.Sink) {
+			defer wg.Done()
+			start := time.Now()
+			if err := s.Write(ctx, samples); err != nil {
+				slog.Error("Error writing metrics", "output", name, "error", err)
+				mu.Lock()
+				failed = append(failed, name)
+				mu.Unlock()
+				return
+			}
+			slog.Debug("Metrics written", "output", name, "duration", time.Since(start))
+		}(name, s)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("%d of %d outputs failed: %s", len(failed), len(sinks), strings.Join(failed, ", "))
+	}
+	return nil
+}
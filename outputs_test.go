@@ -0,0 +1,56 @@
+// Output handling for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func TestAuxiliaryWriter(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		written []time.Time
+	)
+	block := make(chan struct{})
+	rec := metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+		<-block
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, t)
+		return nil
+	})
+	w := newAuxiliaryWriter(map[string]sink.Sink{"rec": rec})
+
+	ts := []collector.Metric{{Name: "m", Value: 1}}
+	t0 := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	t1, t2, t3 := t0.Add(time.Second), t0.Add(2*time.Second), t0.Add(3*time.Second)
+
+	// t0 is being written (blocked) while t2 is queued. t1 arrives late, and
+	// is older than the queued collection.
+	w.write(ts, t0)
+	time.Sleep(20 * time.Millisecond)
+	w.write(ts, t2)
+	w.write(ts, t1)
+	close(block)
+	w.close()
+
+	// Collections after close are dropped.
+	w.write(ts, t3)
+
+	want := []time.Time{t0, t2}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("written collections = %v, want %v", written, want)
+	}
+}
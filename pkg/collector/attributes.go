@@ -0,0 +1,519 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// attribute describes how a SmartThings attribute is exported.
+type attribute struct {
+	// Metric name, without the prefix.
+	name string
+	help string
+
+	convert Converter
+
+	// Attributes holding several values use split instead of convert. It
+	// returns the values keyed by the value of label.
+	label string
+	split func(interface{}) (map[string]float64, error)
+
+	// Attributes with a fixed set of string values (states) use enum
+	// instead of convert, so they can also be exported with one series per
+	// state (see Collector.EnumStyle.)
+	enum enum
+
+	// Counters only go up, except when reset (e.g., energy meters.)
+	counter bool
+
+	// Attributes reported in several units have their values multiplied by
+	// the factor for the unit given by SmartThings. Values without a unit
+	// are taken as already in the unit of the metric; values in units not
+	// listed are rejected.
+	units map[string]float64
+
+	// Temperatures are converted according to Collector.TemperatureUnit,
+	// and the unit is added to name and help (which must have no period.)
+	temperature bool
+}
+
+// attributesMu protects attributes.
+var attributesMu sync.RWMutex
+
+// attributes holds all attributes collected by default (the built-in ones and
+// those added with Register), keyed by the SmartThings attribute name.
+var attributes = map[string]attribute{
+	"acceleration": {
+		name: "acceleration_active",
+		help: "Whether acceleration (vibration) is detected (1) or not (0).",
+		enum: enumOf("inactive", "active"),
+	},
+	"airQuality": {
+		name:    "air_quality_index",
+		help:    "Air quality index (CAQI).",
+		convert: ValueFloat,
+	},
+	"alarmState": {
+		name:    "alarm_clear",
+		help:    "Whether the alarm is clear (1) or not (0).",
+		convert: ValueClear,
+	},
+	"amperage": {
+		name:    "amperage_amperes",
+		help:    "Electric current reported by the amperage attribute, in amperes.",
+		convert: ValueFloat,
+	},
+	"battery": {
+		name:    "battery_percent",
+		help:    "Battery level, in percent.",
+		convert: ValueFloat,
+	},
+	"carbonDioxide": {
+		name:    "carbon_dioxide_ppm",
+		help:    "Carbon dioxide concentration, in parts per million.",
+		convert: ValueFloat,
+	},
+	"carbonMonoxide": {
+		name:    "carbon_monoxide_clear",
+		help:    "Whether no carbon monoxide is detected (1) or not (0).",
+		convert: ValueClear,
+	},
+	"colorTemperature": {
+		name:    "color_temperature_kelvin",
+		help:    "Color temperature of white light, in kelvin.",
+		convert: ValueFloat,
+	},
+	"completionTime": {
+		name:    "completion_timestamp_seconds",
+		help:    "Unix time when the current appliance job is expected to finish.",
+		convert: ValueTimestamp,
+	},
+	"contact": {
+		name: "contact_open",
+		help: "Whether the contact sensor is open (1) or closed (0).",
+		enum: enumOf("closed", "open"),
+	},
+	"coolingSetpoint": {
+		name:        "cooling_setpoint",
+		help:        "Thermostat cooling setpoint",
+		convert:     ValueFloat,
+		temperature: true,
+	},
+	"current": {
+		name:    "current_amperes",
+		help:    "Electric current, in amperes.",
+		convert: ValueFloat,
+	},
+	"deviceHealth": {
+		name: "device_online",
+		help: "Whether SmartThings reports the device as online (1) or not (0).",
+		enum: mapOf(map[string]float64{
+			"OFFLINE": 0,
+			"UNKNOWN": 0,
+			"ONLINE":  1,
+		}),
+	},
+	"door": {
+		name: "door_state",
+		help: "Door state: closed (0), open (1), opening (2), closing (3) or unknown (-1).",
+		enum: mapOf(map[string]float64{
+			"closed":  0,
+			"open":    1,
+			"opening": 2,
+			"closing": 3,
+			"unknown": -1,
+		}),
+	},
+	"dryerJobState": {
+		name: "dryer_job_state",
+		help: "Dryer job state: none (0), weightSensing (1), drying (2), cooling (3), refreshing (4), dehumidifying (5), aiDrying (6), sanitizing (7), internalCare (8), wrinklePrevent (9), delayWash (10), freezeProtection (11), continuousDehumidifying (12), thawingFrozenInside (13), finished (14).",
+		enum: enumOf("none", "weightSensing", "drying", "cooling", "refreshing", "dehumidifying", "aiDrying", "sanitizing", "internalCare", "wrinklePrevent", "delayWash", "freezeProtection", "continuousDehumidifying", "thawingFrozenInside", "finished"),
+	},
+	"dustLevel": {
+		name:    "pm10_micrograms_per_cubic_meter",
+		help:    "PM10 dust level, in micrograms per cubic meter.",
+		convert: ValueFloat,
+	},
+	"energy": {
+		name:    "energy_kwh_total",
+		help:    "Energy meter reading, in kilowatt-hours.",
+		convert: ValueFloat,
+		counter: true,
+		units:   map[string]float64{"Wh": 0.001, "kWh": 1, "MWh": 1000},
+	},
+	"fineDustLevel": {
+		name:    "pm2_5_micrograms_per_cubic_meter",
+		help:    "PM2.5 fine dust level, in micrograms per cubic meter.",
+		convert: ValueFloat,
+	},
+	"heatingSetpoint": {
+		name:        "heating_setpoint",
+		help:        "Thermostat heating setpoint",
+		convert:     ValueFloat,
+		temperature: true,
+	},
+	"hue": {
+		name:    "hue_percent",
+		help:    "Color hue, in percent of the full color circle.",
+		convert: ValueFloat,
+	},
+	"humidity": {
+		name:    "humidity_percent",
+		help:    "Relative humidity, in percent.",
+		convert: ValueFloat,
+	},
+	"illuminance": {
+		name:    "illuminance_lux",
+		help:    "Illuminance, in lux.",
+		convert: ValueFloat,
+	},
+	"level": {
+		name:    "level_percent",
+		help:    "Dimmer level (brightness or speed), in percent.",
+		convert: ValueFloat,
+	},
+	"lock": {
+		name: "lock_locked",
+		help: "Whether the lock is locked (1), unlocked (0) or in an unknown state (-1).",
+		enum: mapOf(map[string]float64{
+			"unlocked":              0,
+			"unlocked with timeout": 0,
+			"locked":                1,
+			"unknown":               -1,
+		}),
+	},
+	"lqi": {
+		name:    "lqi",
+		help:    "Zigbee link quality indicator (0 to 255).",
+		convert: ValueFloat,
+	},
+	"machineState": {
+		name: "machine_state",
+		help: "Appliance machine state: stop (0), run (1), pause (2).",
+		enum: enumOf("stop", "run", "pause"),
+	},
+	"motion": {
+		name: "motion_active",
+		help: "Whether motion is detected (1) or not (0).",
+		enum: enumOf("inactive", "active"),
+	},
+	"power": {
+		name:    "power_watts",
+		help:    "Power meter reading, in watts.",
+		convert: ValueFloat,
+	},
+	"powerSource": {
+		name: "power_source",
+		help: "Power source: mains (0), battery (1), dc (2) or unknown (-1).",
+		enum: mapOf(map[string]float64{
+			"mains":   0,
+			"battery": 1,
+			"dc":      2,
+			"unknown": -1,
+		}),
+	},
+	"presence": {
+		name: "present",
+		help: "Whether the presence sensor is present (1) or not (0).",
+		enum: enumOf("not present", "present"),
+	},
+	"rssi": {
+		name:    "rssi_dbm",
+		help:    "Received signal strength, in dBm.",
+		convert: ValueFloat,
+	},
+	"saturation": {
+		name:    "saturation_percent",
+		help:    "Color saturation, in percent.",
+		convert: ValueFloat,
+	},
+	"shadeLevel": {
+		name:    "shade_level_percent",
+		help:    "Window shade level, in percent open.",
+		convert: ValueFloat,
+	},
+	"shock": {
+		name: "shock_detected",
+		help: "Whether a shock is detected (1) or not (0).",
+		enum: enumOf("clear", "detected"),
+	},
+	"smoke": {
+		name:    "smoke_clear",
+		help:    "Whether no smoke is detected (1) or not (0).",
+		convert: ValueClear,
+	},
+	"soundPressureLevel": {
+		name:    "sound_pressure_level_decibels",
+		help:    "Sound pressure level, in decibels.",
+		convert: ValueFloat,
+	},
+	"switch": {
+		name: "switch_on",
+		help: "Whether the switch is on (1) or off (0).",
+		enum: enumOf("off", "on"),
+	},
+	"tamper": {
+		name: "tamper_detected",
+		help: "Whether tampering is detected (1) or not (0).",
+		enum: enumOf("clear", "detected"),
+	},
+	"temperature": {
+		name:        "temperature",
+		help:        "Temperature",
+		convert:     ValueFloat,
+		temperature: true,
+	},
+	"thermostatFanMode": {
+		name: "thermostat_fan_mode",
+		help: "Thermostat fan mode: auto (0), on (1), circulate (2) or follow schedule (3).",
+		enum: mapOf(map[string]float64{
+			"auto":           0,
+			"on":             1,
+			"circulate":      2,
+			"followschedule": 3,
+		}),
+	},
+	"thermostatMode": {
+		name: "thermostat_mode",
+		help: "Thermostat mode: off (0), heat (1), cool (2), auto (3), emergency heat (4) or eco (5).",
+		enum: mapOf(map[string]float64{
+			"off":            0,
+			"heat":           1,
+			"cool":           2,
+			"auto":           3,
+			"emergency heat": 4,
+			"eco":            5,
+		}),
+	},
+	"thermostatOperatingState": {
+		name: "thermostat_operating_state",
+		help: "Thermostat operating state: idle (0), heating (1), cooling (2), fan only (3), pending heat (4), pending cool (5) or vent economizer (6).",
+		enum: mapOf(map[string]float64{
+			"idle":            0,
+			"heating":         1,
+			"cooling":         2,
+			"fan only":        3,
+			"pending heat":    4,
+			"pending cool":    5,
+			"vent economizer": 6,
+		}),
+	},
+	"threeAxis": {
+		name:  "three_axis",
+		help:  "Acceleration along each axis, in milli-g.",
+		label: "axis",
+		split: splitThreeAxis,
+	},
+	"tvocLevel": {
+		name:    "tvoc_ppm",
+		help:    "Total volatile organic compounds, in parts per million.",
+		convert: ValueFloat,
+	},
+	"ultravioletIndex": {
+		name:    "ultraviolet_index",
+		help:    "Ultraviolet index.",
+		convert: ValueFloat,
+	},
+	"valve": {
+		name: "valve_open",
+		help: "Whether the valve is open (1) or closed (0).",
+		enum: enumOf("closed", "open"),
+	},
+	"veryFineDustLevel": {
+		name:    "pm1_micrograms_per_cubic_meter",
+		help:    "PM1.0 very fine dust level, in micrograms per cubic meter.",
+		convert: ValueFloat,
+	},
+	"voltage": {
+		name:    "voltage_volts",
+		help:    "Voltage, in volts.",
+		convert: ValueFloat,
+	},
+	"washerJobState": {
+		name: "washer_job_state",
+		help: "Washer job state: none (0), weightSensing (1), preWash (2), wash (3), rinse (4), spin (5), drying (6), cooling (7), airWash (8), wrinklePrevent (9), delayWash (10), freezeProtection (11), finish (12).",
+		enum: enumOf("none", "weightSensing", "preWash", "wash", "rinse", "spin", "drying", "cooling", "airWash", "wrinklePrevent", "delayWash", "freezeProtection", "finish"),
+	},
+	"water": {
+		name: "water_detected",
+		help: "Whether water is detected (1) or not (0).",
+		enum: enumOf("dry", "wet"),
+	},
+	"windowShade": {
+		name: "window_shade",
+		help: "Window shade state: closed (0), open (1), partially open (2), opening (3), closing (4), unknown (5).",
+		enum: enumOf("closed", "open", "partially open", "opening", "closing", "unknown"),
+	},
+}
+
+// healthConcernLevels holds the valid values of health concern attributes,
+// from best to worst.
+var healthConcernLevels = []string{"good", "moderate", "slightlyUnhealthy", "unhealthy", "veryUnhealthy", "hazardous"}
+
+// healthConcerns holds the attributes reporting health concern levels, and
+// what the concern is about.
+var healthConcerns = map[string]string{
+	"carbonDioxideHealthConcern":    "carbon dioxide",
+	"dustHealthConcern":             "PM10 dust",
+	"fineDustHealthConcern":         "PM2.5 fine dust",
+	"veryFineDustHealthConcern":     "PM1.0 very fine dust",
+	"tvocHealthConcern":             "volatile organic compounds",
+	"radonHealthConcern":            "radon",
+	"formaldehydeHealthConcern":     "formaldehyde",
+	"airQualityHealthConcern":       "air quality",
+	"odorSensorHealthConcern":       "odor",
+	"carbonMonoxideHealthConcern":   "carbon monoxide",
+	"sulfurDioxideHealthConcern":    "sulfur dioxide",
+	"nitrogenDioxideHealthConcern":  "nitrogen dioxide",
+	"ozoneHealthConcern":            "ozone",
+	"ultravioletIndexHealthConcern": "ultraviolet index",
+}
+
+// Health concern attributes share the same ordinal encoding.
+func init() {
+	for attr, what := range healthConcerns {
+		attributes[attr] = attribute{
+			name: metricName(attr),
+			help: fmt.Sprintf("Health concern level for %s: %s.", what, enumHelp(healthConcernLevels)),
+			enum: enumOf(healthConcernLevels...),
+		}
+	}
+}
+
+// Register adds support for a SmartThings attribute, or replaces the built-in
+// support for it. The attribute is converted with conv and exported as the
+// metric with the given name (plus the collector prefix) and help text. If name
+// is empty, the attribute name converted to snake case is used.
+//
+// Register is meant to be called during initialization (e.g., from the init
+// function of a package with additional converters). Converters set in
+// Collector.Converters take precedence over registered ones.
+func Register(attr, name, help string, conv ValueConverter) {
+	if name == "" {
+		name = metricName(attr)
+	}
+	attributesMu.Lock()
+	defer attributesMu.Unlock()
+	a := attribute{name: name, help: help}
+	if e, ok := conv.(enum); ok {
+		a.enum = e
+	} else {
+		a.convert = conv.Convert
+	}
+	attributes[attr] = a
+}
+
+// lookupAttribute returns the description of a supported attribute.
+func lookupAttribute(attr string) (attribute, bool) {
+	attributesMu.RLock()
+	defer attributesMu.RUnlock()
+	a, ok := attributes[attr]
+	return a, ok
+}
+
+// temperatureUnits holds the names of the temperature units reported by
+// SmartThings.
+var temperatureUnits = map[string]string{
+	"C": TemperatureCelsius,
+	"F": TemperatureFahrenheit,
+}
+
+// unitNames holds the names of temperature units used in help texts.
+var unitNames = map[string]string{
+	TemperatureCelsius:    "Celsius",
+	TemperatureFahrenheit: "Fahrenheit",
+}
+
+// convertTemperature converts a temperature from one unit to another.
+func convertTemperature(v float64, from, to string) float64 {
+	switch {
+	case from == TemperatureFahrenheit && to == TemperatureCelsius:
+		return (v - 32) * 5 / 9
+	case from == TemperatureCelsius && to == TemperatureFahrenheit:
+		return v*9/5 + 32
+	}
+	return v
+}
+
+// enum holds the numeric value of each valid state of an attribute.
+type enum map[string]float64
+
+// Convert implements ValueConverter, returning the value of the state v.
+func (e enum) Convert(v interface{}) (float64, error) {
+	return ValueMap(v, e)
+}
+
+// enumOf returns an enum with the position of each state in values.
+func enumOf(values ...string) enum {
+	m := enum{}
+	for n, v := range values {
+		m[v] = float64(n)
+	}
+	return m
+}
+
+// mapOf returns an enum with the given values.
+func mapOf(values map[string]float64) enum {
+	return enum(values)
+}
+
+// splitThreeAxis returns the x, y and z values of a threeAxis attribute. The
+// value can be a list of three numbers (REST API), a map with x, y and z keys,
+// or a string with three comma separated numbers, optionally enclosed in
+// parentheses (legacy API.)
+func splitThreeAxis(v interface{}) (map[string]float64, error) {
+	var parts []interface{}
+	switch val := v.(type) {
+	case []interface{}:
+		parts = val
+	case map[string]interface{}:
+		parts = []interface{}{val["x"], val["y"], val["z"]}
+	case string:
+		for _, p := range strings.Split(strings.Trim(val, "()[] "), ",") {
+			parts = append(parts, strings.TrimSpace(p))
+		}
+	default:
+		return nil, fmt.Errorf("invalid type for threeAxis value \"%v\": %T", v, v)
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid threeAxis value \"%v\": expected three values", v)
+	}
+
+	ret := map[string]float64{}
+	for n, axis := range []string{"x", "y", "z"} {
+		f, err := ValueFloat(parts[n])
+		if err != nil {
+			return nil, err
+		}
+		ret[axis] = f
+	}
+	return ret, nil
+}
+
+// metricName converts a SmartThings attribute name (in camel case) into a
+// metric name suffix (in snake case), e.g. "formaldehydeLevel" becomes
+// "formaldehyde_level".
+func metricName(attr string) string {
+	var b strings.Builder
+	for i, r := range attr {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ButtonCounter counts button presses by device, component and action
+// (pushed, held, double, etc.) A press is detected whenever the value or the update time of
+// the button attribute of a device changes between collections, so presses
+// repeating the last action are only detected with sources reporting update
+// times (see Timestamped.)
+//
+// The state of the counter can be saved and restored with encoding/json to
+// keep counting across runs.
+type ButtonCounter struct {
+	mu      sync.Mutex
+	devices map[string]*buttonState
+}
+
+// buttonState holds the last button action seen for a device, and the
+// number of presses by action.
+type buttonState struct {
+	Last   string             `json:"last"`
+	Time   time.Time          `json:"time"`
+	Counts map[string]float64 `json:"counts"`
+}
+
+// NewButtonCounter returns a new ButtonCounter with all counts set to zero.
+func NewButtonCounter() *ButtonCounter {
+	return &ButtonCounter{devices: map[string]*buttonState{}}
+}
+
+// Len returns the number of button devices seen by the counter.
+func (b *ButtonCounter) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.devices)
+}
+
+// observe records the current value of the button attributes of all
+// components of a device. The first value seen for a button only sets the
+// initial state.
+func (b *ButtonCounter) observe(id string, attrs map[string]interface{}) {
+	for k, v := range attrs {
+		if component, name := splitKey(k); name == "button" {
+			b.observeButton(buttonKey(id, component), v)
+		}
+	}
+}
+
+// observeButton records the current value of a single button.
+func (b *ButtonCounter) observeButton(key string, v interface{}) {
+	val, t := valueOf(v)
+	action, ok := val.(string)
+	if !ok || action == "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.devices[key]
+	if !ok {
+		b.devices[key] = &buttonState{Last: action, Time: t, Counts: map[string]float64{}}
+		return
+	}
+	if action == st.Last && t.Equal(st.Time) {
+		return
+	}
+	st.Last, st.Time = action, t
+	st.Counts[action]++
+}
+
+// metrics returns the press counters for all buttons of a device, sorted by
+// component and action.
+func (b *ButtonCounter) metrics(prefix string, dev Device) []Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := []string{}
+	for key := range b.devices {
+		if key == dev.ID || strings.HasPrefix(key, dev.ID+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	ret := []Metric{}
+	for _, key := range keys {
+		component := "main"
+		if i := strings.Index(key, "/"); i >= 0 {
+			component = key[i+1:]
+		}
+		st := b.devices[key]
+		actions := []string{}
+		for action := range st.Counts {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+
+		for _, action := range actions {
+			ret = append(ret, Metric{
+				Name:      prefix + "button_presses_total",
+				Labels:    append(deviceLabels(dev), Label{"component", component}, Label{"action", action}),
+				Value:     st.Counts[action],
+				Help:      "Number of button presses, by action.",
+				Attribute: "button",
+				Counter:   true,
+			})
+		}
+	}
+	return ret
+}
+
+// buttonKey returns the key of a button in the counter state. Buttons in the
+// main component are keyed by the device ID alone.
+func buttonKey(id, component string) string {
+	if component == "main" {
+		return id
+	}
+	return id + "/" + component
+}
+
+// MarshalJSON implements json.Marshaler.
+func (b *ButtonCounter) MarshalJSON() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return json.Marshal(b.devices)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, replacing the current state.
+func (b *ButtonCounter) UnmarshalJSON(data []byte) error {
+	devices := map[string]*buttonState{}
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return err
+	}
+	for _, st := range devices {
+		if st.Counts == nil {
+			st.Counts = map[string]float64{}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.devices = devices
+	return nil
+}
@@ -0,0 +1,130 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Cache is a Source holding the last known state of all devices. It is
+// populated from another Source with Refresh and can be updated in real time
+// with Update (e.g., from SmartThings device events.)
+type Cache struct {
+	// MaxConcurrency is the maximum number of devices fetched concurrently
+	// by Refresh. Values below one mean one device at a time.
+	MaxConcurrency int
+
+	src Source
+
+	mu      sync.RWMutex
+	devices []Device
+	attrs   map[string]map[string]interface{}
+}
+
+// NewCache returns a new (empty) Cache for src.
+func NewCache(src Source) *Cache {
+	return &Cache{
+		src:   src,
+		attrs: map[string]map[string]interface{}{},
+	}
+}
+
+// Refresh replaces the contents of the cache with the current state of all
+// devices in the underlying Source. Devices that cannot be read keep their
+// last known state, and the error for the first of them is returned. The
+// devices of accounts that cannot be read (see AccountErrors) are kept as
+// well, and the AccountErrors is returned.
+func (c *Cache) Refresh(ctx context.Context) error {
+	devs, err := c.src.Devices(ctx)
+	var failed AccountErrors
+	if err != nil && !errors.As(err, &failed) {
+		return err
+	}
+	a, errs := fetchAttributes(ctx, c.src, devs, c.MaxConcurrency)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	attrs := map[string]map[string]interface{}{}
+	for n, dev := range devs {
+		if errs[n] != nil {
+			// New devices that cannot be read start with no attributes.
+			prev := c.attrs[dev.ID]
+			if prev == nil {
+				prev = map[string]interface{}{}
+			}
+			attrs[dev.ID] = prev
+			continue
+		}
+		attrs[dev.ID] = a[n]
+	}
+	for _, dev := range c.devices {
+		if _, ok := failed[dev.Account]; ok {
+			devs = append(devs, dev)
+			if _, ok := attrs[dev.ID]; !ok {
+				attrs[dev.ID] = c.attrs[dev.ID]
+			}
+		}
+	}
+	c.devices = devs
+	c.attrs = attrs
+	if err != nil {
+		return err
+	}
+	return firstError(errs)
+}
+
+// Update sets the value of a single attribute of a device, identified by its
+// key (see AttributeKey). Updates for devices not returned by the last Refresh
+// are ignored, and Update returns false.
+func (c *Cache) Update(id, key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	attrs, ok := c.attrs[id]
+	if !ok {
+		return false
+	}
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+		c.attrs[id] = attrs
+	}
+	attrs[key] = value
+	return true
+}
+
+// Unit returns the unit of the cached value of an attribute, or an empty
+// string if unknown.
+func (c *Cache) Unit(id, key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return unitOf(c.attrs[id][key])
+}
+
+// Devices returns the list of all devices in the cache.
+func (c *Cache) Devices(ctx context.Context) ([]Device, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]Device, len(c.devices))
+	copy(ret, c.devices)
+	return ret, nil
+}
+
+// Attributes returns the last known value of all attributes of a device.
+func (c *Cache) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := map[string]interface{}{}
+	for k, v := range c.attrs[dev.ID] {
+		ret[k] = v
+	}
+	return ret, nil
+}
@@ -0,0 +1,124 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeSource is a Source returning fixed devices and attributes. Devices
+// listed in fail return an error from Attributes.
+type fakeSource struct {
+	devices []Device
+	attrs   map[string]map[string]interface{}
+	fail    map[string]bool
+}
+
+func (s *fakeSource) Devices(ctx context.Context) ([]Device, error) {
+	return s.devices, nil
+}
+
+func (s *fakeSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	if s.fail[dev.ID] {
+		return nil, errors.New("attributes failed")
+	}
+	ret := map[string]interface{}{}
+	for k, v := range s.attrs[dev.ID] {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func TestCache(t *testing.T) {
+	casetests := []struct {
+		name    string
+		fail    map[string]bool
+		prime   bool
+		update  bool
+		wantErr bool
+		want    map[string]interface{}
+	}{
+		{
+			name: "refresh ok",
+			want: map[string]interface{}{"switch": "on"},
+		},
+		{
+			name:    "new device fails",
+			fail:    map[string]bool{"a": true},
+			wantErr: true,
+			want:    map[string]interface{}{},
+		},
+		{
+			name:    "new device fails then update",
+			fail:    map[string]bool{"a": true},
+			update:  true,
+			wantErr: true,
+			want:    map[string]interface{}{"power": 10.0},
+		},
+		{
+			name:    "known device keeps last state",
+			fail:    map[string]bool{"a": true},
+			prime:   true,
+			wantErr: true,
+			want:    map[string]interface{}{"switch": "on"},
+		},
+		{
+			name:   "update after refresh",
+			update: true,
+			want:   map[string]interface{}{"switch": "on", "power": 10.0},
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range casetests {
+		src := &fakeSource{
+			devices: []Device{{ID: "a", Name: "Lamp"}},
+			attrs:   map[string]map[string]interface{}{"a": {"switch": "on"}},
+		}
+		c := NewCache(src)
+		if tt.prime {
+			if err := c.Refresh(ctx); err != nil {
+				t.Fatalf("%s: priming refresh failed: %v", tt.name, err)
+			}
+		}
+		src.fail = tt.fail
+		err := c.Refresh(ctx)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Refresh error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.update {
+			c.Update("a", "power", 10.0)
+		}
+
+		devs, _ := c.Devices(ctx)
+		if len(devs) != 1 || devs[0].Name != "Lamp" {
+			t.Errorf("%s: Devices = %v, want a single device named Lamp", tt.name, devs)
+		}
+		got, _ := c.Attributes(ctx, Device{ID: "a"})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Attributes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCacheUpdateUnknownDevice(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(&fakeSource{})
+	if c.Update("b", "switch", "off") {
+		t.Errorf("Update of unknown device returned true, want false")
+	}
+
+	if devs, _ := c.Devices(ctx); len(devs) != 0 {
+		t.Errorf("Devices = %v, want none", devs)
+	}
+	if got, _ := c.Attributes(ctx, Device{ID: "b"}); len(got) != 0 {
+		t.Errorf("Attributes = %v, want none", got)
+	}
+}
@@ -0,0 +1,586 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+// Package collector fetches sensor data from SmartThings devices and converts
+// it into Prometheus compatible metrics.
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultPrefix is the default prefix for the names of device metrics.
+const DefaultPrefix = "smartthings_"
+
+// Valid values for Collector.TemperatureUnit.
+const (
+	TemperatureCelsius    = "celsius"
+	TemperatureFahrenheit = "fahrenheit"
+	TemperatureRaw        = "raw"
+)
+
+// Valid values for Collector.EnumStyle.
+const (
+	EnumNumeric    = "numeric"
+	EnumStateLabel = "statelabel"
+)
+
+// Collector fetches data from all devices reachable through a Source.
+type Collector struct {
+	// Prefix is prepended to the names of all device metrics. Metrics about
+	// the collection itself always use the "smartcollector_" prefix.
+	Prefix string
+
+	// TemperatureUnit is the unit temperatures are converted to, and added
+	// to the metric name (e.g. temperature_celsius). With TemperatureRaw,
+	// values are not converted and the unit reported by SmartThings goes into
+	// the unit label instead. Temperatures of unknown unit (legacy API) are
+	// always exported that way, with an empty unit label.
+	TemperatureUnit string
+
+	// EnumStyle selects how attributes with a fixed set of states (e.g.
+	// contact or thermostatMode) are exported. With EnumNumeric, the value
+	// is the number associated with the current state. With EnumStateLabel,
+	// there is one series per state, told apart by the state label, set to 1
+	// for the current state and 0 for the others. These metrics are named
+	// after the attribute in snake case (e.g. thermostat_mode.) Attributes
+	// converted by Converters are always numeric.
+	EnumStyle string
+
+	// Filter, if not nil, is called for every device. Only devices for which
+	// it returns true are collected.
+	Filter func(dev Device) bool
+
+	// Converters holds converters for additional attributes, or overrides
+	// for the built-in ones.
+	//
+	// Use Reconfigure to change Filter, Converters, BatteryLow and Relabel
+	// while collections may be running.
+	Converters map[string]Converter
+
+	// BatteryLow, if not nil, returns the battery level (in percent) below
+	// which the battery of a device is considered low, exported as the
+	// battery_low metric. attrs holds all attributes of the device.
+	BatteryLow func(dev Device, attrs map[string]interface{}) float64
+
+	// Labels are added to all metrics (including those about the collection
+	// itself), except those already having a label with the same name.
+	Labels []Label
+
+	// Relabel, if not nil, is called for every metric (including those about
+	// the collection itself) before returning it from Collect. It returns
+	// the metric to export, with its name or labels changed as needed, or
+	// false to drop it.
+	Relabel func(m Metric) (Metric, bool)
+
+	// MaxConcurrency is the maximum number of devices fetched concurrently.
+	// Values below one mean one device at a time.
+	MaxConcurrency int
+
+	// Strict makes Collect fail on the first device error. By default,
+	// devices that cannot be read or processed are skipped, reported to
+	// OnError (if set) and flagged in the smartcollector_device_error metric.
+	Strict bool
+
+	// OnError, if not nil, is called for every device skipped due to errors.
+	OnError func(dev Device, err error)
+
+	// OnAccountError, if not nil, is called for every account skipped due
+	// to errors (see AccountErrors). Like device errors, these only make
+	// Collect fail with Strict, and are flagged in the
+	// smartcollector_account_error metric.
+	OnAccountError func(account string, err error)
+
+	// Buttons, if not nil, counts presses of button devices, exported as
+	// the button_presses_total counter.
+	Buttons *ButtonCounter
+
+	// APIErrors counts the failed API requests (including retried ones),
+	// exported as smartcollector_api_errors_total. Requests are counted by
+	// the Source (see NewRetrySource) sharing this counter.
+	APIErrors *ErrorCounter
+
+	src Source
+
+	// Time and result of the last collection.
+	mu          sync.Mutex
+	lastCollect time.Time
+	lastErr     error
+}
+
+// Device holds the basic identification of a SmartThings device.
+type Device struct {
+	ID   string
+	Name string
+
+	// Location ID and name. These are empty with the legacy API, where
+	// each endpoint corresponds to a single location.
+	LocationID string
+	Location   string
+
+	// Account name, when collecting from multiple accounts.
+	Account string
+
+	// Name of the room the device is in, and of the hub it is paired to (or
+	// its ID, if unknown), if any. Always empty with the legacy API.
+	Room string
+	Hub  string
+
+	// Integration type (e.g. ZIGBEE or ZWAVE), device type handler name,
+	// manufacturer name and code, model and firmware version, exported in the
+	// device_info metric when known. These are always empty with the legacy
+	// API.
+	Type             string
+	DeviceType       string
+	Manufacturer     string
+	ManufacturerCode string
+	Model            string
+	Firmware         string
+
+	// Current mode of the location and names of all its modes, exported as
+	// the location_mode metric. Only set by sources wrapped with
+	// NewModeSource.
+	Mode  string
+	Modes []string
+
+	// Inventory of the location, exported as the location_scenes,
+	// location_rules and location_installed_apps metrics. Only set by
+	// sources wrapped with NewInventorySource.
+	Inventory *Inventory
+
+	// Capabilities holds the IDs of the capabilities supported by each
+	// component of the device, exported as the device_capability metric.
+	// Always empty with the legacy API.
+	Capabilities map[string][]string
+}
+
+// New returns a new Collector fetching data from src.
+func New(src Source) *Collector {
+	return &Collector{
+		Prefix:          DefaultPrefix,
+		TemperatureUnit: TemperatureFahrenheit,
+		EnumStyle:       EnumNumeric,
+		APIErrors:       &ErrorCounter{},
+		src:             src,
+	}
+}
+
+// Devices returns the list of all devices, ignoring the filter.
+func (c *Collector) Devices(ctx context.Context) ([]Device, error) {
+	return c.src.Devices(ctx)
+}
+
+// Reconfigure replaces the filter, converters, low battery thresholds and
+// relabeling function used by the collector. It is safe to call while
+// collections are running; those will finish with the old settings.
+func (c *Collector) Reconfigure(filter func(dev Device) bool, converters map[string]Converter, batteryLow func(dev Device, attrs map[string]interface{}) float64, relabel func(m Metric) (Metric, bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Filter = filter
+	c.Converters = converters
+	c.BatteryLow = batteryLow
+	c.Relabel = relabel
+}
+
+// Status returns the time and the error returned by the last call to Collect.
+// The time is zero if Collect has not been called yet.
+func (c *Collector) Status() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastCollect, c.lastErr
+}
+
+// Collect iterates over all devices selected by the filter and returns the
+// metrics for all of them, followed by metrics about the collection itself.
+func (c *Collector) Collect(ctx context.Context) ([]Metric, error) {
+	m, err := c.collect(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCollect = time.Now()
+	c.lastErr = err
+	return m, err
+}
+
+// collect implements Collect.
+func (c *Collector) collect(ctx context.Context) ([]Metric, error) {
+	start := time.Now()
+
+	c.mu.Lock()
+	filter, converters, batteryLow, relabel := c.Filter, c.Converters, c.BatteryLow, c.Relabel
+	c.mu.Unlock()
+
+	devs, err := c.Devices(ctx)
+	var failed AccountErrors
+	if err != nil && (c.Strict || !errors.As(err, &failed)) {
+		return nil, err
+	}
+
+	selected := []Device{}
+	for _, dev := range devs {
+		if filter == nil || filter(dev) {
+			selected = append(selected, dev)
+		}
+	}
+
+	attrs, errs := fetchAttributes(ctx, c.src, selected, c.MaxConcurrency)
+	if c.Strict {
+		if err := firstError(errs); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := []Metric{}
+	for n, dev := range selected {
+		err := errs[n]
+		if err == nil {
+			var m []Metric
+			if m, err = c.deviceMetrics(dev, attrs[n], converters); err != nil {
+				err = fmt.Errorf("error processing sensor data: %v", err)
+				if c.Strict {
+					return nil, err
+				}
+			}
+			ret = append(ret, m...)
+			if batteryLow != nil && err == nil {
+				ret = append(ret, batteryLowMetrics(c.Prefix, dev, attrs[n], batteryLow(dev, attrs[n]))...)
+			}
+			if c.Buttons != nil {
+				c.Buttons.observe(dev.ID, attrs[n])
+			}
+		}
+		if c.Buttons != nil {
+			ret = append(ret, c.Buttons.metrics(c.Prefix, dev)...)
+		}
+		ret = append(ret, deviceInfoMetric(c.Prefix, dev))
+		ret = append(ret, capabilityMetrics(c.Prefix, dev)...)
+
+		// Context errors affect all devices; it makes no sense to continue.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		value := 0.0
+		if err != nil {
+			value = 1.0
+			if c.OnError != nil {
+				c.OnError(dev, err)
+			}
+		}
+		ret = append(ret, Metric{
+			Name:   "smartcollector_device_error",
+			Labels: deviceLabels(dev),
+			Value:  value,
+			Help:   "Whether the last collection from the device failed (1) or not (0).",
+		})
+	}
+
+	ret = append(ret, accountErrorMetrics(devs, failed, c.OnAccountError)...)
+	ret = append(ret, locationModeMetrics(c.Prefix, selected)...)
+	ret = append(ret, inventoryMetrics(c.Prefix, selected)...)
+
+	ret = append(ret,
+		Metric{
+			Name:  "smartcollector_scrape_duration_seconds",
+			Value: time.Since(start).Seconds(),
+			Help:  "Time taken to collect all devices, in seconds.",
+		},
+		Metric{
+			Name:  "smartcollector_devices_total",
+			Value: float64(len(selected)),
+			Help:  "Number of devices collected.",
+		},
+		Metric{
+			Name:    "smartcollector_api_errors_total",
+			Value:   float64(c.APIErrors.Value()),
+			Help:    "Number of failed SmartThings API requests, including retried ones.",
+			Counter: true,
+		},
+		Metric{
+			Name:  "smartcollector_last_success_timestamp_seconds",
+			Value: float64(time.Now().Unix()),
+			Help:  "Unix time of the last successful collection.",
+		},
+	)
+	addLabels(ret, c.Labels)
+	sanitizeLabels(ret)
+	if relabel != nil {
+		ret = relabelMetrics(ret, relabel)
+	}
+	return ret, nil
+}
+
+// accountErrorMetrics returns the smartcollector_account_error metrics for
+// the accounts of all devices and the failed accounts, sorted by account
+// name, calling onError (if not nil) for each failed account. Nothing is
+// returned for devices not tagged with an account (single account
+// collections.)
+func accountErrorMetrics(devs []Device, failed AccountErrors, onError func(account string, err error)) []Metric {
+	accounts := map[string]bool{}
+	for _, dev := range devs {
+		if dev.Account != "" {
+			accounts[dev.Account] = true
+		}
+	}
+	for name := range failed {
+		accounts[name] = true
+	}
+	names := []string{}
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := []Metric{}
+	for _, name := range names {
+		value := 0.0
+		if err, ok := failed[name]; ok {
+			value = 1.0
+			if onError != nil {
+				onError(name, err)
+			}
+		}
+		ret = append(ret, Metric{
+			Name:   "smartcollector_account_error",
+			Labels: []Label{{"account", name}},
+			Value:  value,
+			Help:   "Whether the last collection of the device list of the account failed (1) or not (0).",
+		})
+	}
+	return ret
+}
+
+// relabelMetrics returns the metrics returned by relabel, skipping dropped
+// ones.
+func relabelMetrics(ms []Metric, relabel func(m Metric) (Metric, bool)) []Metric {
+	ret := []Metric{}
+	for _, m := range ms {
+		if m, ok := relabel(m); ok {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// deviceInfoMetric returns the device_info metric for a device, always set to
+// 1, with the device information in labels.
+func deviceInfoMetric(prefix string, dev Device) Metric {
+	return Metric{
+		Name: prefix + "device_info",
+		Labels: append(deviceLabels(dev),
+			Label{"type", dev.Type},
+			Label{"device_type", dev.DeviceType},
+			Label{"model", dev.Model},
+			Label{"manufacturer", dev.Manufacturer},
+			Label{"manufacturer_code", dev.ManufacturerCode},
+			Label{"firmware", dev.Firmware},
+		),
+		Value: 1,
+		Help:  "Device information, in labels. Always 1.",
+	}
+}
+
+// capabilityMetrics returns one device_capability metric, always set to 1, for
+// every capability of every component of a device, sorted by component.
+func capabilityMetrics(prefix string, dev Device) []Metric {
+	components := []string{}
+	for comp := range dev.Capabilities {
+		components = append(components, comp)
+	}
+	sort.Strings(components)
+
+	ret := []Metric{}
+	for _, comp := range components {
+		for _, capability := range dev.Capabilities[comp] {
+			ret = append(ret, Metric{
+				Name:   prefix + "device_capability",
+				Labels: append(deviceLabels(dev), Label{"component", comp}, Label{"capability", capability}),
+				Value:  1,
+				Help:   "Capabilities supported by the device, in labels. Always 1.",
+			})
+		}
+	}
+	return ret
+}
+
+// batteryLowMetrics returns the battery_low metric for a device, set to 1 if
+// the battery level is below threshold and 0 otherwise. Nothing is returned
+// for devices without a (valid) battery level.
+func batteryLowMetrics(prefix string, dev Device, attrs map[string]interface{}, threshold float64) []Metric {
+	v, _ := valueOf(attrs["battery"])
+	if v == nil {
+		return nil
+	}
+	level, err := ValueFloat(v)
+	if err != nil {
+		return nil
+	}
+	value := 0.0
+	if level < threshold {
+		value = 1.0
+	}
+	return []Metric{{
+		Name:      prefix + "battery_low",
+		Labels:    deviceLabels(dev),
+		Value:     value,
+		Help:      "Whether the battery level is below the configured threshold (1) or not (0).",
+		Attribute: "battery",
+	}}
+}
+
+// deviceMetrics returns the metrics for all known attributes of a device
+// (in all components), sorted by attribute key, with names starting with prefix. Attributes
+// present in converters are converted using the corresponding converter,
+// taking precedence over the built-in attribute list. The metrics are followed
+// by the time of the last update to any attribute, when known.
+func (c *Collector) deviceMetrics(dev Device, attrs map[string]interface{}, converters map[string]Converter) ([]Metric, error) {
+	keys := []string{}
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := []Metric{}
+	var last time.Time
+
+	for _, k := range keys {
+		val, t := valueOf(attrs[k])
+		if t.After(last) {
+			last = t
+		}
+
+		// Some sensors report nil as a value (instead of a blank string) so we
+		// convert nil to an empty string to avoid issues with type assertion.
+		if val == nil {
+			val = ""
+		}
+
+		component, name := splitKey(k)
+		attr, known := lookupAttribute(name)
+		conv, ok := converters[name]
+		switch {
+		case ok && !known:
+			attr = attribute{
+				name: metricName(name),
+				help: fmt.Sprintf("Value of the SmartThings %s attribute.", name),
+			}
+		case !ok && known:
+			conv = attr.convert
+		case !ok:
+			// We only process keys we know about.
+			continue
+		}
+
+		// Attributes holding several values produce one metric per value,
+		// told apart by the attribute's label.
+		values := map[string]float64{}
+		label := ""
+		switch {
+		case conv != nil:
+			value, err := conv(val)
+			if err != nil {
+				return nil, err
+			}
+			values[""] = value
+		case attr.enum != nil && c.EnumStyle == EnumStateLabel:
+			// States we don't know about get their own series.
+			state, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid non-string argument %v", val)
+			}
+			for st := range attr.enum {
+				values[st] = 0
+			}
+			values[state] = 1
+			attr.name = metricName(name)
+			attr.help = fmt.Sprintf("State of the SmartThings %s attribute: 1 for the current state, 0 for the others.", name)
+			label = "state"
+		case attr.enum != nil:
+			// Devices may report states we don't know about (e.g., new
+			// appliance job states), exported as -1 like unknown door states.
+			value, err := attr.enum.Convert(val)
+			if err != nil {
+				if _, ok := val.(string); !ok {
+					return nil, err
+				}
+				value = -1
+			}
+			values[""] = value
+		default:
+			var err error
+			if values, err = attr.split(val); err != nil {
+				return nil, err
+			}
+			label = attr.label
+		}
+
+		if unit := unitOf(attrs[k]); attr.units != nil && unit != "" {
+			f, ok := attr.units[unit]
+			if !ok {
+				return nil, fmt.Errorf("unsupported unit %q for the %s attribute", unit, name)
+			}
+			for v := range values {
+				values[v] *= f
+			}
+		}
+
+		metric, help, labels := attr.name, attr.help, append(deviceLabels(dev), Label{"component", component})
+		if attr.temperature {
+			// Temperatures of unknown unit are exported as in raw mode,
+			// with an empty unit label, since they cannot be converted.
+			unit := temperatureUnits[unitOf(attrs[k])]
+			if c.TemperatureUnit == TemperatureRaw || unit == "" {
+				help += ", in the unit given by the unit label."
+				labels = append(labels, Label{"unit", unit})
+			} else {
+				for v := range values {
+					values[v] = convertTemperature(values[v], unit, c.TemperatureUnit)
+				}
+				metric += "_" + c.TemperatureUnit
+				help += ", in degrees " + unitNames[c.TemperatureUnit] + "."
+			}
+		}
+
+		state, _ := val.(string)
+
+		valueNames := []string{}
+		for v := range values {
+			valueNames = append(valueNames, v)
+		}
+		sort.Strings(valueNames)
+
+		for _, v := range valueNames {
+			l := append([]Label{}, labels...)
+			if label != "" {
+				l = append(l, Label{label, v})
+			}
+			ret = append(ret, Metric{
+				Name:      c.Prefix + metric,
+				Labels:    l,
+				Value:     values[v],
+				Help:      help,
+				Attribute: name,
+				Counter:   attr.counter,
+				Time:      t,
+				State:     state,
+			})
+		}
+	}
+	if !last.IsZero() {
+		ret = append(ret, Metric{
+			Name:   c.Prefix + "device_last_activity_timestamp_seconds",
+			Labels: deviceLabels(dev),
+			Value:  float64(last.Unix()),
+			Help:   "Unix time of the last update to any attribute of the device.",
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,224 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTemperatureUnits(t *testing.T) {
+	casetests := []struct {
+		name      string
+		unit      string
+		value     interface{}
+		wantName  string
+		wantValue float64
+		wantUnit  string
+	}{
+		{
+			name:      "fahrenheit to celsius",
+			unit:      TemperatureCelsius,
+			value:     Timestamped{Value: 212.0, Unit: "F"},
+			wantName:  "smartthings_temperature_celsius",
+			wantValue: 100,
+		},
+		{
+			name:      "celsius to fahrenheit",
+			unit:      TemperatureFahrenheit,
+			value:     Timestamped{Value: 100.0, Unit: "C"},
+			wantName:  "smartthings_temperature_fahrenheit",
+			wantValue: 212,
+		},
+		{
+			name:      "raw",
+			unit:      TemperatureRaw,
+			value:     Timestamped{Value: 21.5, Unit: "C"},
+			wantName:  "smartthings_temperature",
+			wantValue: 21.5,
+			wantUnit:  TemperatureCelsius,
+		},
+		{
+			name:      "unknown unit",
+			unit:      TemperatureFahrenheit,
+			value:     21.5,
+			wantName:  "smartthings_temperature",
+			wantValue: 21.5,
+		},
+		{
+			name:      "unsupported unit",
+			unit:      TemperatureCelsius,
+			value:     Timestamped{Value: 294.65, Unit: "K"},
+			wantName:  "smartthings_temperature",
+			wantValue: 294.65,
+		},
+	}
+
+	for _, tt := range casetests {
+		c := New(nil)
+		c.TemperatureUnit = tt.unit
+		ms, err := c.deviceMetrics(Device{ID: "t1"}, map[string]interface{}{"temperature": tt.value}, nil)
+		if err != nil {
+			t.Fatalf("%s: deviceMetrics failed: %v", tt.name, err)
+		}
+		if len(ms) == 0 {
+			t.Fatalf("%s: no metrics returned", tt.name)
+		}
+		m := ms[0]
+		if m.Name != tt.wantName {
+			t.Errorf("%s: name = %q, want %q", tt.name, m.Name, tt.wantName)
+		}
+		if math.Abs(m.Value-tt.wantValue) > 1e-9 {
+			t.Errorf("%s: value = %v, want %v", tt.name, m.Value, tt.wantValue)
+		}
+		hasUnit := false
+		for _, l := range m.Labels {
+			hasUnit = hasUnit || l.Name == "unit"
+		}
+		if raw := tt.wantName == "smartthings_temperature"; hasUnit != raw {
+			t.Errorf("%s: unit label present = %v, want %v", tt.name, hasUnit, raw)
+		}
+		if got := m.Label("unit"); got != tt.wantUnit {
+			t.Errorf("%s: unit label = %q, want %q", tt.name, got, tt.wantUnit)
+		}
+	}
+}
+
+func TestUnknownEnumStates(t *testing.T) {
+	attrs := map[string]interface{}{
+		"contact":        "open",
+		"thermostatMode": "dryair",
+		"washerJobState": "rush hour",
+	}
+	casetests := []struct {
+		style string
+		want  map[string]float64
+	}{
+		{
+			style: EnumNumeric,
+			want: map[string]float64{
+				"smartthings_contact_open":     1,
+				"smartthings_thermostat_mode":  -1,
+				"smartthings_washer_job_state": -1,
+			},
+		},
+		{
+			style: EnumStateLabel,
+			want: map[string]float64{
+				"smartthings_contact/open":               1,
+				"smartthings_contact/closed":             0,
+				"smartthings_thermostat_mode/dryair":     1,
+				"smartthings_thermostat_mode/off":        0,
+				"smartthings_washer_job_state/rush hour": 1,
+			},
+		},
+	}
+
+	for _, tt := range casetests {
+		c := New(nil)
+		c.EnumStyle = tt.style
+		ms, err := c.deviceMetrics(Device{ID: "d1"}, attrs, nil)
+		if err != nil {
+			t.Fatalf("%s: deviceMetrics failed: %v", tt.style, err)
+		}
+		got := map[string]float64{}
+		for _, m := range ms {
+			key := m.Name
+			if tt.style == EnumStateLabel {
+				key += "/" + m.Label("state")
+			}
+			got[key] = m.Value
+		}
+		for k, v := range tt.want {
+			if g, ok := got[k]; !ok || g != v {
+				t.Errorf("%s: %s = %v (present: %v), want %v", tt.style, k, g, ok, v)
+			}
+		}
+	}
+}
+
+func TestAPIErrorsCounter(t *testing.T) {
+	src := &fakeSource{
+		devices: []Device{{ID: "a"}, {ID: "b"}},
+		fail:    map[string]bool{"b": true},
+	}
+	c := New(nil)
+	c.src = NewRetrySource(src, 1, time.Millisecond, c.APIErrors)
+
+	// Every failed attempt (two per collection) is counted, and errors
+	// accumulate across collections.
+	for want := 2.0; want <= 6; want += 2 {
+		ms, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect failed: %v", err)
+		}
+		found := false
+		for _, m := range ms {
+			if m.Name != "smartcollector_api_errors_total" {
+				continue
+			}
+			found = true
+			if !m.Counter {
+				t.Errorf("smartcollector_api_errors_total is not a counter")
+			}
+			if m.Value != want {
+				t.Errorf("smartcollector_api_errors_total = %v, want %v", m.Value, want)
+			}
+		}
+		if !found {
+			t.Fatalf("smartcollector_api_errors_total not found")
+		}
+	}
+}
+
+func TestEnergyUnits(t *testing.T) {
+	casetests := []struct {
+		name    string
+		value   interface{}
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:  "kilowatt-hours",
+			value: Timestamped{Value: 12.5, Unit: "kWh"},
+			want:  12.5,
+		},
+		{
+			name:  "watt-hours",
+			value: Timestamped{Value: 12500.0, Unit: "Wh"},
+			want:  12.5,
+		},
+		{
+			name:  "no unit",
+			value: 12.5,
+			want:  12.5,
+		},
+		{
+			name:    "unsupported unit",
+			value:   Timestamped{Value: 12.5, Unit: "kVAh"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		ms, err := New(nil).deviceMetrics(Device{ID: "e1"}, map[string]interface{}{"energy": tt.value}, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: deviceMetrics error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if len(ms) == 0 {
+			t.Fatalf("%s: no metrics returned", tt.name)
+		}
+		if m := ms[0]; m.Name != "smartthings_energy_kwh_total" || !m.Counter || math.Abs(m.Value-tt.want) > 1e-9 {
+			t.Errorf("%s: got %s = %v (counter: %v), want smartthings_energy_kwh_total counter = %v", tt.name, m.Name, m.Value, m.Counter, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ValueConverter converts raw attribute values into float64 values.
+type ValueConverter interface {
+	Convert(v interface{}) (float64, error)
+}
+
+// Converter converts a raw attribute value into a float64. It implements
+// ValueConverter, so plain functions can be used wherever a ValueConverter
+// is needed.
+type Converter func(interface{}) (float64, error)
+
+// Convert implements ValueConverter.
+func (f Converter) Convert(v interface{}) (float64, error) {
+	return f(v)
+}
+
+// ValueClear expects a string and returns 0 for "clear", 1 for anything else.
+// TODO: Expand this to properly identify non-clear conditions and return error
+// in case an unexpected value is found.
+func ValueClear(v interface{}) (float64, error) {
+	val, ok := v.(string)
+	if !ok {
+		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
+	}
+	if val != "clear" {
+		return 0.0, nil
+	}
+	return 1.0, nil
+}
+
+// ValueOneOf returns 0.0 if the value matches the first item
+// in the array, 1.0 if it matches the second, and an error if
+// nothing matches.
+func ValueOneOf(v interface{}, options []string) (float64, error) {
+	val, ok := v.(string)
+	if !ok {
+		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
+	}
+	if val == options[0] {
+		return 0.0, nil
+	}
+	if val == options[1] {
+		return 1.0, nil
+	}
+	return 0.0, fmt.Errorf("invalid option %q. Expected %q or %q", val, options[0], options[1])
+}
+
+// ValueMap expects a string and returns the value associated with it in
+// values, or an error if the string is not present.
+func ValueMap(v interface{}, values map[string]float64) (float64, error) {
+	val, ok := v.(string)
+	if !ok {
+		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
+	}
+	ret, ok := values[val]
+	if !ok {
+		return 0.0, fmt.Errorf("invalid option %q", val)
+	}
+	return ret, nil
+}
+
+// ValueFloat returns the float64 value of the value passed or
+// error if the value cannot be converted. Accepts float64 and
+// strings as valid arguments.
+func ValueFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case string:
+		ret, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0.0, fmt.Errorf("unable to convert %q to float: %v", val, v)
+		}
+		return ret, nil
+	case float64:
+		ret, ok := v.(float64)
+		if !ok {
+			return 0.0, fmt.Errorf("unable to convert \"%v\" to string", v)
+		}
+		return ret, nil
+	}
+	return 0.0, fmt.Errorf("invalid type for \"%v\": %T", v, v)
+}
+
+// ValueTimestamp expects a string with an RFC 3339 time (e.g.
+// "2016-05-01T10:00:00Z") and returns it as the number of seconds since the
+// Unix epoch.
+func ValueTimestamp(v interface{}) (float64, error) {
+	val, ok := v.(string)
+	if !ok {
+		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return 0.0, fmt.Errorf("unable to convert %q to time: %v", val, err)
+	}
+	return float64(t.UnixNano()) / 1e9, nil
+}
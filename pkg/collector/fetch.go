@@ -0,0 +1,58 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// fetchAttributes reads the attributes of all devices from src using up to
+// workers concurrent requests (a value below one means one.) The returned
+// slices are in the same order as devs and hold the attributes or the error
+// returned for each device.
+func fetchAttributes(ctx context.Context, src Source, devs []Device, workers int) ([]map[string]interface{}, []error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	attrs := make([]map[string]interface{}, len(devs))
+	errs := make([]error, len(devs))
+
+	idx := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range idx {
+				if err := ctx.Err(); err != nil {
+					errs[n] = err
+					continue
+				}
+				attrs[n], errs[n] = src.Attributes(ctx, devs[n])
+			}
+		}()
+	}
+	for n := range devs {
+		idx <- n
+	}
+	close(idx)
+	wg.Wait()
+
+	return attrs, errs
+}
+
+// firstError returns the first non-nil error in errs, or nil.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// healthSource wraps a Source, adding the connectivity state of each device
+// as reported by the SmartThings device health API.
+type healthSource struct {
+	Source
+	client *smartthings.Client
+}
+
+// NewHealthSource returns a Source that adds the device health state (ONLINE,
+// OFFLINE or UNKNOWN) to the attributes returned by src, as the deviceHealth
+// attribute. This requires one additional API request per device.
+func NewHealthSource(src Source, client *smartthings.Client) Source {
+	return &healthSource{
+		Source: src,
+		client: client,
+	}
+}
+
+func (s *healthSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	attrs, err := s.Source.Attributes(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+	health, err := s.client.DeviceHealth(ctx, dev.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading device health: %w", err)
+	}
+	attrs["deviceHealth"] = health.State
+	return attrs, nil
+}
@@ -0,0 +1,171 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// Inventory holds the scenes, automation rules and installed SmartApps of a
+// location.
+type Inventory struct {
+	Scenes        []InventoryItem
+	Rules         []InventoryItem
+	InstalledApps []InventoryItem
+}
+
+// InventoryItem identifies a scene, rule or installed SmartApp. Type and
+// status are empty when SmartThings doesn't report them.
+type InventoryItem struct {
+	ID     string
+	Name   string
+	Type   string
+	Status string
+}
+
+// inventorySource wraps a Source, adding the inventory of the location of
+// each device.
+type inventorySource struct {
+	Source
+	client *smartthings.Client
+}
+
+// NewInventorySource returns a Source that sets the Inventory field of the
+// devices returned by src, exported as the location_scenes, location_rules
+// and location_installed_apps metrics (and one info metric per item). This
+// requires at least three additional API requests per location.
+func NewInventorySource(src Source, client *smartthings.Client) Source {
+	return &inventorySource{
+		Source: src,
+		client: client,
+	}
+}
+
+func (s *inventorySource) Devices(ctx context.Context) ([]Device, error) {
+	devs, err := s.Source.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventories := map[string]*Inventory{}
+	for n, dev := range devs {
+		if dev.LocationID == "" {
+			continue
+		}
+		inv, ok := inventories[dev.LocationID]
+		if !ok {
+			if inv, err = s.inventory(ctx, dev.LocationID); err != nil {
+				return nil, err
+			}
+			inventories[dev.LocationID] = inv
+		}
+		devs[n].Inventory = inv
+	}
+	return devs, nil
+}
+
+// inventory fetches the inventory of a location.
+func (s *inventorySource) inventory(ctx context.Context, locationID string) (*Inventory, error) {
+	inv := &Inventory{}
+
+	scenes, err := s.client.Scenes(ctx, locationID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of scenes: %w", err)
+	}
+	for _, sc := range scenes {
+		inv.Scenes = append(inv.Scenes, InventoryItem{ID: sc.SceneID, Name: sc.SceneName})
+	}
+
+	rules, err := s.client.Rules(ctx, locationID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of rules: %w", err)
+	}
+	for _, r := range rules {
+		inv.Rules = append(inv.Rules, InventoryItem{ID: r.ID, Name: r.Name, Status: r.Status})
+	}
+
+	apps, err := s.client.InstalledApps(ctx, locationID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of installed apps: %w", err)
+	}
+	for _, app := range apps {
+		inv.InstalledApps = append(inv.InstalledApps, InventoryItem{
+			ID:     app.InstalledAppID,
+			Name:   app.DisplayName,
+			Type:   app.InstalledAppType,
+			Status: app.InstalledAppStatus,
+		})
+	}
+	return inv, nil
+}
+
+// inventoryMetrics returns the inventory metrics for the locations of all
+// devices. Locations are sorted by account and location ID.
+func inventoryMetrics(prefix string, devs []Device) []Metric {
+	seen := map[string]bool{}
+	locs := []Device{}
+	for _, dev := range devs {
+		key := dev.Account + "/" + dev.LocationID
+		if dev.Inventory == nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+		locs = append(locs, dev)
+	}
+	sortLocations(locs)
+
+	ret := []Metric{}
+	for _, loc := range locs {
+		labels := []Label{{"location", loc.Location}, {"account", loc.Account}}
+		for _, kind := range []struct {
+			name, help string
+			items      []InventoryItem
+		}{
+			{"scene", "scenes", loc.Inventory.Scenes},
+			{"rule", "automation rules", loc.Inventory.Rules},
+			{"installed_app", "installed SmartApps", loc.Inventory.InstalledApps},
+		} {
+			ret = append(ret, Metric{
+				Name:   prefix + "location_" + kind.name + "s",
+				Labels: labels,
+				Value:  float64(len(kind.items)),
+				Help:   fmt.Sprintf("Number of %s in the location.", kind.help),
+			})
+			for _, item := range kind.items {
+				l := append([]Label{}, labels...)
+				l = append(l, Label{"id", item.ID}, Label{"name", item.Name})
+				if kind.name == "rule" || kind.name == "installed_app" {
+					l = append(l, Label{"status", item.Status})
+				}
+				if kind.name == "installed_app" {
+					l = append(l, Label{"type", item.Type})
+				}
+				ret = append(ret, Metric{
+					Name:   prefix + kind.name + "_info",
+					Labels: l,
+					Value:  1,
+					Help:   fmt.Sprintf("Information about %s, in labels. Always 1.", kind.help),
+				})
+			}
+		}
+	}
+	return ret
+}
+
+// sortLocations sorts devices representing locations by account and location
+// ID.
+func sortLocations(locs []Device) {
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].Account != locs[j].Account {
+			return locs[i].Account < locs[j].Account
+		}
+		return locs[i].LocationID < locs[j].LocationID
+	})
+}
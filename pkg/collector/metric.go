@@ -0,0 +1,126 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Label is a name/value pair attached to a metric.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metric holds a single sample of a device attribute.
+type Metric struct {
+	Name   string
+	Labels []Label
+	Value  float64
+
+	// Help is a short description of the metric family, used in the HELP
+	// metadata line of the exposition format.
+	Help string
+
+	// Attribute is the name of the SmartThings attribute the metric comes
+	// from. It is empty for metrics about the collection itself.
+	Attribute string
+
+	// Counter is true for metrics whose value only goes up (e.g., number of
+	// button presses). Other metrics are gauges.
+	Counter bool
+
+	// Time is when SmartThings last updated the attribute, or the zero time
+	// if unknown.
+	Time time.Time
+
+	// State is the value of the attribute as reported by SmartThings, for
+	// attributes with string values (e.g., "open" or "wet"). It is empty
+	// for numeric attributes and metrics not about an attribute.
+	State string
+}
+
+// deviceLabels returns the labels identifying a device.
+func deviceLabels(dev Device) []Label {
+	return []Label{
+		{"id", dev.ID},
+		{"name", dev.Name},
+		{"location", dev.Location},
+		{"room", dev.Room},
+		{"hub", dev.Hub},
+		{"account", dev.Account},
+	}
+}
+
+// Label returns the value of the label with the given name, or an empty
+// string if the metric has no such label.
+func (m Metric) Label(name string) string {
+	for _, l := range m.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// labelEscaper escapes label values as required by the Prometheus text
+// exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// addLabels adds the labels to all metrics, except those already having a
+// label with the same name. Label slices are copied, since metrics may share
+// them.
+func addLabels(ms []Metric, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+	for i := range ms {
+		l := append([]Label{}, ms[i].Labels...)
+		for _, extra := range labels {
+			if !ms[i].hasLabel(extra.Name) {
+				l = append(l, extra)
+			}
+		}
+		ms[i].Labels = l
+	}
+}
+
+// hasLabel returns true if the metric has a label with the given name.
+func (m Metric) hasLabel(name string) bool {
+	for _, l := range m.Labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeLabels replaces invalid UTF-8 sequences in the label values of all
+// metrics with the Unicode replacement character. Device names and other
+// values come straight from SmartThings, and Prometheus rejects label values
+// that are not valid UTF-8, failing the whole scrape.
+func sanitizeLabels(ms []Metric) {
+	for i := range ms {
+		for j, l := range ms[i].Labels {
+			ms[i].Labels[j].Value = strings.ToValidUTF8(l.Value, "\uFFFD")
+		}
+	}
+}
+
+// String returns the metric as a line in the Prometheus text exposition format.
+// Label values are escaped as needed.
+func (m Metric) String() string {
+	if len(m.Labels) == 0 {
+		return fmt.Sprintf("%s %v", m.Name, m.Value)
+	}
+	labels := []string{}
+	for _, l := range m.Labels {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", l.Name, labelEscaper.Replace(l.Value)))
+	}
+	return fmt.Sprintf("%s{%s} %v", m.Name, strings.Join(labels, ","), m.Value)
+}
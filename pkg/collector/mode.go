@@ -0,0 +1,105 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// modeSource wraps a Source, adding the current mode of the location of each
+// device, as reported by the SmartThings location modes API.
+type modeSource struct {
+	Source
+	client *smartthings.Client
+}
+
+// NewModeSource returns a Source that sets the Mode and Modes fields of the
+// devices returned by src, exported as the location_mode metric. This
+// requires two additional API requests per location.
+func NewModeSource(src Source, client *smartthings.Client) Source {
+	return &modeSource{
+		Source: src,
+		client: client,
+	}
+}
+
+func (s *modeSource) Devices(ctx context.Context) ([]Device, error) {
+	devs, err := s.Source.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]string{}
+	modes := map[string][]string{}
+	for n, dev := range devs {
+		if dev.LocationID == "" {
+			continue
+		}
+		if _, ok := modes[dev.LocationID]; !ok {
+			if current[dev.LocationID], modes[dev.LocationID], err = s.locationModes(ctx, dev.LocationID); err != nil {
+				return nil, err
+			}
+		}
+		devs[n].Mode = current[dev.LocationID]
+		devs[n].Modes = modes[dev.LocationID]
+	}
+	return devs, nil
+}
+
+// locationModes returns the name of the current mode of a location, and the
+// names of all its modes.
+func (s *modeSource) locationModes(ctx context.Context, locationID string) (string, []string, error) {
+	all, err := s.client.Modes(ctx, locationID)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading location modes: %w", err)
+	}
+	mode, err := s.client.CurrentMode(ctx, locationID)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading current location mode: %w", err)
+	}
+	names := []string{}
+	for _, m := range all {
+		names = append(names, m.DisplayName())
+	}
+	return mode.DisplayName(), names, nil
+}
+
+// locationModeMetrics returns the location_mode metrics for the locations of
+// all devices, with one series per mode, set to 1 for the current mode and 0
+// for the others. Locations are sorted by account and location ID.
+func locationModeMetrics(prefix string, devs []Device) []Metric {
+	seen := map[string]bool{}
+	locs := []Device{}
+	for _, dev := range devs {
+		key := dev.Account + "/" + dev.LocationID
+		if dev.Mode == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		locs = append(locs, dev)
+	}
+	sortLocations(locs)
+
+	ret := []Metric{}
+	for _, loc := range locs {
+		for _, mode := range loc.Modes {
+			value := 0.0
+			if mode == loc.Mode {
+				value = 1.0
+			}
+			ret = append(ret, Metric{
+				Name:   prefix + "location_mode",
+				Labels: []Label{{"location", loc.Location}, {"account", loc.Account}, {"mode", mode}},
+				Value:  value,
+				Help:   "Location mode: 1 for the current mode, 0 for the others.",
+			})
+		}
+	}
+	return ret
+}
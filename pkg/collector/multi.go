@@ -0,0 +1,108 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// AccountErrors is returned by Sources merging several accounts when the
+// devices of some (but not all) accounts cannot be read. It holds the error
+// for each failed account, indexed by account name. The devices of all other
+// accounts are returned along with it.
+type AccountErrors map[string]error
+
+func (e AccountErrors) Error() string {
+	names := []string{}
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := []string{}
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("account %s: %v", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// deviceKey identifies a device in an account. The same device may be shared
+// by several accounts.
+type deviceKey struct {
+	account string
+	id      string
+}
+
+// multiSource merges the devices of several Sources (accounts).
+type multiSource struct {
+	srcs map[string]Source
+
+	// Source for each device, filled by Devices.
+	mu     sync.Mutex
+	routes map[deviceKey]Source
+}
+
+// NewMultiSource returns a Source merging the devices from several Sources,
+// indexed by account name. Every device is tagged with its account name.
+// Accounts failing to return their devices are skipped and reported in an
+// AccountErrors; Devices only fails if all accounts fail.
+func NewMultiSource(srcs map[string]Source) Source {
+	return &multiSource{
+		srcs:   srcs,
+		routes: map[deviceKey]Source{},
+	}
+}
+
+func (s *multiSource) Devices(ctx context.Context) ([]Device, error) {
+	names := []string{}
+	for name := range s.srcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := []Device{}
+	routes := map[deviceKey]Source{}
+	failed := AccountErrors{}
+	for _, name := range names {
+		devs, err := s.srcs[name].Devices(ctx)
+		if err != nil {
+			failed[name] = err
+			continue
+		}
+		for _, dev := range devs {
+			dev.Account = name
+			routes[deviceKey{name, dev.ID}] = s.srcs[name]
+			ret = append(ret, dev)
+		}
+	}
+	if len(failed) == len(names) && len(names) > 0 {
+		return nil, failed
+	}
+
+	s.mu.Lock()
+	s.routes = routes
+	s.mu.Unlock()
+
+	if len(failed) > 0 {
+		return ret, failed
+	}
+	return ret, nil
+}
+
+func (s *multiSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	s.mu.Lock()
+	src, ok := s.routes[deviceKey{dev.Account, dev.ID}]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown device %s in account %s", dev.ID, dev.Account)
+	}
+	return src.Attributes(ctx, dev)
+}
@@ -0,0 +1,183 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMultiSource(t *testing.T) {
+	// The same device ID is shared by accounts "a" and "b", with different
+	// values in each.
+	shared := func(value string) Source {
+		return &fakeSource{
+			devices: []Device{{ID: "d1"}},
+			attrs:   map[string]map[string]interface{}{"d1": {"switch": value}},
+		}
+	}
+	down := func() Source {
+		return &failingSource{err: errors.New("down"), fails: 1000}
+	}
+
+	casetests := []struct {
+		name       string
+		srcs       map[string]Source
+		want       map[string]string
+		wantFailed []string
+		wantErr    bool
+	}{
+		{
+			name: "all accounts ok",
+			srcs: map[string]Source{"a": shared("on"), "b": shared("off")},
+			want: map[string]string{"a/d1": "on", "b/d1": "off"},
+		},
+		{
+			name:       "one account fails",
+			srcs:       map[string]Source{"a": shared("on"), "b": down()},
+			want:       map[string]string{"a/d1": "on"},
+			wantFailed: []string{"b"},
+		},
+		{
+			name:    "all accounts fail",
+			srcs:    map[string]Source{"a": down(), "b": down()},
+			wantErr: true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range casetests {
+		src := NewMultiSource(tt.srcs)
+		devs, err := src.Devices(ctx)
+
+		var failed AccountErrors
+		if errors.As(err, &failed) && tt.wantFailed != nil {
+			got := []string{}
+			for name := range failed {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantFailed) {
+				t.Errorf("%s: failed accounts = %v, want %v", tt.name, got, tt.wantFailed)
+			}
+		} else if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Devices error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			if devs != nil {
+				t.Errorf("%s: Devices returned %v, want none", tt.name, devs)
+			}
+			continue
+		}
+
+		got := map[string]string{}
+		for _, dev := range devs {
+			attrs, err := src.Attributes(ctx, dev)
+			if err != nil {
+				t.Errorf("%s: Attributes(%s/%s) failed: %v", tt.name, dev.Account, dev.ID, err)
+				continue
+			}
+			got[dev.Account+"/"+dev.ID], _ = attrs["switch"].(string)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: attributes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAccountErrors(t *testing.T) {
+	ok := &fakeSource{
+		devices: []Device{{ID: "d1"}},
+		attrs:   map[string]map[string]interface{}{"d1": {"switch": "on"}},
+	}
+	down := &failingSource{err: errors.New("down"), fails: 1000}
+
+	casetests := []struct {
+		name    string
+		strict  bool
+		want    map[string]float64
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: map[string]float64{"a": 0, "b": 1},
+		},
+		{
+			name:    "strict",
+			strict:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		c := New(NewMultiSource(map[string]Source{"a": ok, "b": down}))
+		c.Strict = tt.strict
+		var skipped []string
+		c.OnAccountError = func(account string, err error) {
+			skipped = append(skipped, account)
+		}
+
+		ms, err := c.Collect(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Collect error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got := map[string]float64{}
+		for _, m := range ms {
+			if m.Name == "smartcollector_account_error" {
+				got[m.Labels[0].Value] = m.Value
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: smartcollector_account_error = %v, want %v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(skipped, []string{"b"}) {
+			t.Errorf("%s: OnAccountError called for %v, want [b]", tt.name, skipped)
+		}
+	}
+}
+
+func TestCacheAccountErrors(t *testing.T) {
+	srcB := &fakeSource{
+		devices: []Device{{ID: "d2"}},
+		attrs:   map[string]map[string]interface{}{"d2": {"switch": "off"}},
+	}
+	srcs := map[string]Source{
+		"a": &fakeSource{
+			devices: []Device{{ID: "d1"}},
+			attrs:   map[string]map[string]interface{}{"d1": {"switch": "on"}},
+		},
+		"b": srcB,
+	}
+	c := NewCache(NewMultiSource(srcs))
+	ctx := context.Background()
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	// Devices of failed accounts keep their last known state.
+	srcs["b"] = &failingSource{err: errors.New("down"), fails: 1000}
+	var failed AccountErrors
+	if err := c.Refresh(ctx); !errors.As(err, &failed) {
+		t.Fatalf("Refresh error = %v, want AccountErrors", err)
+	}
+	devs, _ := c.Devices(ctx)
+	got := map[string]interface{}{}
+	for _, dev := range devs {
+		attrs, _ := c.Attributes(ctx, dev)
+		got[dev.Account+"/"+dev.ID] = attrs["switch"]
+	}
+	want := map[string]interface{}{"a/d1": "on", "b/d2": "off"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cached attributes = %v, want %v", got, want)
+	}
+}
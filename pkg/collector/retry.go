@@ -0,0 +1,110 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/retry"
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// ErrorCounter counts failed API requests. It is safe for concurrent use.
+type ErrorCounter struct {
+	mu sync.Mutex
+	n  int
+}
+
+// Add adds n to the count of failed requests.
+func (c *ErrorCounter) Add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += n
+}
+
+// Value returns the count of failed requests.
+func (c *ErrorCounter) Value() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// retrySource wraps a Source, retrying requests that fail with transient
+// errors.
+type retrySource struct {
+	src     Source
+	retries int
+	delay   time.Duration
+	errs    *ErrorCounter
+}
+
+// NewRetrySource returns a Source that retries failed requests to src up to
+// retries times, with exponential backoff starting at delay. Requests
+// rejected by SmartThings with an HTTP 4xx response (e.g., an invalid token or
+// an unknown device) are not retried, except for 408 and 429. All other
+// errors are retried (see Permanent.) Every failed attempt is counted in errs,
+// if not nil.
+func NewRetrySource(src Source, retries int, delay time.Duration, errs *ErrorCounter) Source {
+	return &retrySource{
+		src:     src,
+		retries: retries,
+		delay:   delay,
+		errs:    errs,
+	}
+}
+
+func (s *retrySource) Devices(ctx context.Context) ([]Device, error) {
+	var devs []Device
+	err := retry.Do(ctx, s.retries, s.delay, func() error {
+		var err error
+		devs, err = s.src.Devices(ctx)
+		return s.failed(err)
+	})
+	return devs, err
+}
+
+func (s *retrySource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	var attrs map[string]interface{}
+	err := retry.Do(ctx, s.retries, s.delay, func() error {
+		var err error
+		attrs, err = s.src.Attributes(ctx, dev)
+		return s.failed(err)
+	})
+	return attrs, err
+}
+
+// failed counts err (if not nil) as a failed request, and returns it wrapped
+// as needed by Permanent.
+func (s *retrySource) failed(err error) error {
+	if err != nil && s.errs != nil {
+		s.errs.Add(1)
+	}
+	return Permanent(err)
+}
+
+// Permanent returns err wrapped with retry.Permanent if retrying the request
+// cannot help: an HTTP 4xx response from SmartThings other than 408 (Request
+// Timeout) and 429 (Too Many Requests), or a canceled context. Other errors,
+// including network errors, timeouts, 5xx responses and the errors of the
+// legacy API client (which carry no status code), are returned as is.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return retry.Permanent(err)
+	}
+	var apiErr *smartthings.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+		apiErr.StatusCode != http.StatusRequestTimeout && apiErr.StatusCode != http.StatusTooManyRequests {
+		return retry.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// failingSource is a Source whose requests fail with err the first fails
+// times.
+type failingSource struct {
+	err   error
+	fails int
+	calls int
+}
+
+func (s *failingSource) Devices(ctx context.Context) ([]Device, error) {
+	s.calls++
+	if s.calls <= s.fails {
+		return nil, s.err
+	}
+	return []Device{{ID: "a"}}, nil
+}
+
+func (s *failingSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	s.calls++
+	if s.calls <= s.fails {
+		return nil, s.err
+	}
+	return map[string]interface{}{"switch": "on"}, nil
+}
+
+func TestRetrySource(t *testing.T) {
+	casetests := []struct {
+		name      string
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "server error",
+			err:       &smartthings.Error{StatusCode: 503},
+			wantCalls: 3,
+		},
+		{
+			name:      "too many requests",
+			err:       &smartthings.Error{StatusCode: 429},
+			wantCalls: 3,
+		},
+		{
+			name:      "network error",
+			err:       &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			wantCalls: 3,
+		},
+		{
+			name:      "timeout",
+			err:       context.DeadlineExceeded,
+			wantCalls: 3,
+		},
+		{
+			name:      "unauthorized",
+			err:       &smartthings.Error{StatusCode: 401},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "not found",
+			err:       &smartthings.Error{StatusCode: 404},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "request timeout",
+			err:       &smartthings.Error{StatusCode: 408},
+			wantCalls: 3,
+		},
+		{
+			name:      "legacy API error",
+			err:       errors.New("invalid character '<' looking for beginning of value"),
+			wantCalls: 3,
+		},
+		{
+			name:      "canceled",
+			err:       context.Canceled,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range casetests {
+		// Fail twice: transient errors succeed on the third attempt.
+		src := &failingSource{err: tt.err, fails: 2}
+		errs := &ErrorCounter{}
+		_, err := NewRetrySource(src, 3, time.Millisecond, errs).Devices(ctx)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Devices error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr && !errors.Is(err, tt.err) {
+			t.Errorf("%s: Devices error = %v, want %v", tt.name, err, tt.err)
+		}
+		if src.calls != tt.wantCalls {
+			t.Errorf("%s: Devices made %d calls, want %d", tt.name, src.calls, tt.wantCalls)
+		}
+		// Every failed attempt is counted.
+		wantErrs := src.calls
+		if wantErrs > src.fails {
+			wantErrs = src.fails
+		}
+		if errs.Value() != wantErrs {
+			t.Errorf("%s: counted %d errors, want %d", tt.name, errs.Value(), wantErrs)
+		}
+
+		src = &failingSource{err: tt.err, fails: 2}
+		_, err = NewRetrySource(src, 3, time.Millisecond, nil).Attributes(ctx, Device{ID: "a"})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Attributes error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if src.calls != tt.wantCalls {
+			t.Errorf("%s: Attributes made %d calls, want %d", tt.name, src.calls, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/gosmart"
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// Source is a backend capable of fetching device data from SmartThings.
+type Source interface {
+	// Devices returns the list of all devices.
+	Devices(ctx context.Context) ([]Device, error)
+
+	// Attributes returns the current value of all attributes of a device
+	// (as returned by Devices), indexed by attribute name. Attributes of
+	// components other than "main" are indexed by "<component>/<attribute>"
+	// (see AttributeKey.) Values may be wrapped in a Timestamped.
+	Attributes(ctx context.Context, dev Device) (map[string]interface{}, error)
+}
+
+// Timestamped is an attribute value along with the time it was last updated
+// and, for measurements, its unit (e.g. "F" or "C" for temperatures). Sources
+// return it instead of the plain value when the time or unit are known.
+type Timestamped struct {
+	Value interface{}
+	Time  time.Time
+	Unit  string
+}
+
+// AttributeKey returns the key of an attribute of a device component in the
+// maps returned by Source.Attributes.
+func AttributeKey(component, attr string) string {
+	if component == "" || component == "main" {
+		return attr
+	}
+	return component + "/" + attr
+}
+
+// splitKey returns the component and attribute name of a key returned by
+// AttributeKey.
+func splitKey(key string) (component, attr string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "main", key
+}
+
+// valueOf returns the plain value of an attribute and the time it was last
+// updated, or the zero time if unknown.
+func valueOf(v interface{}) (interface{}, time.Time) {
+	if ts, ok := v.(Timestamped); ok {
+		return ts.Value, ts.Time
+	}
+	return v, time.Time{}
+}
+
+// unitOf returns the unit of an attribute value, or an empty string if
+// unknown.
+func unitOf(v interface{}) string {
+	if ts, ok := v.(Timestamped); ok {
+		return ts.Unit
+	}
+	return ""
+}
+
+// legacySource fetches data using the legacy Groovy SmartApp endpoint.
+type legacySource struct {
+	client   *http.Client
+	endpoint string
+}
+
+// NewLegacySource returns a Source for the legacy SmartApp API, using an
+// authorized HTTP client and the SmartThings endpoint URI (as returned by
+// gosmart.GetEndPointsURI).
+func NewLegacySource(client *http.Client, endpoint string) Source {
+	return &legacySource{
+		client:   client,
+		endpoint: endpoint,
+	}
+}
+
+func (s *legacySource) Devices(ctx context.Context) ([]Device, error) {
+	var devs []gosmart.DeviceList
+	err := withContext(ctx, func() error {
+		var err error
+		devs, err = gosmart.GetDevices(s.client, s.endpoint)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of devices: %w", err)
+	}
+	ret := []Device{}
+	for _, dev := range devs {
+		ret = append(ret, Device{ID: dev.ID, Name: dev.DisplayName})
+	}
+	return ret, nil
+}
+
+func (s *legacySource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	var devinfo *gosmart.DeviceInfo
+	err := withContext(ctx, func() error {
+		var err error
+		devinfo, err = gosmart.GetDeviceInfo(s.client, s.endpoint, dev.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading device info: %w", err)
+	}
+	return devinfo.Attributes, nil
+}
+
+// withContext runs fn and returns its result, or the context error if ctx is
+// done first. This is used with the gosmart library, which does not support
+// contexts. Set a timeout in the HTTP client as well to make sure abandoned
+// requests eventually finish.
+func withContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// V1Source is a Source fetching data using the SmartThings Cloud REST API.
+type V1Source struct {
+	// CustomCapabilities enables the collection of attributes of custom
+	// capabilities (those with namespaced IDs, like
+	// "samsungce.washerOperatingState"). Their definitions are fetched once,
+	// and all numeric and enum attributes are registered (see Register) as
+	// "<capability>.<attribute>".
+	CustomCapabilities bool
+
+	client *smartthings.Client
+
+	// Custom capabilities already registered, temperature scale by location
+	// and location of each device.
+	mu        sync.Mutex
+	caps      map[string]bool
+	scales    map[string]string
+	locations map[string]string
+}
+
+// NewV1Source returns a Source for the SmartThings Cloud REST API.
+func NewV1Source(client *smartthings.Client) *V1Source {
+	return &V1Source{
+		client:    client,
+		caps:      map[string]bool{},
+		scales:    map[string]string{},
+		locations: map[string]string{},
+	}
+}
+
+func (s *V1Source) Devices(ctx context.Context) ([]Device, error) {
+	devs, err := s.client.Devices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of devices: %w", err)
+	}
+	locs, err := s.client.Locations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list of locations: %w", err)
+	}
+	locNames := map[string]string{}
+	roomNames := map[string]string{}
+	for _, loc := range locs {
+		locNames[loc.LocationID] = loc.Name
+		s.loadScale(ctx, loc.LocationID)
+
+		rooms, err := s.client.Rooms(ctx, loc.LocationID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading list of rooms: %w", err)
+		}
+		for _, room := range rooms {
+			roomNames[room.RoomID] = room.Name
+		}
+	}
+
+	// Hubs are devices too. Index their names by ID.
+	names := map[string]string{}
+	for _, dev := range devs {
+		names[dev.DeviceID] = deviceName(dev)
+	}
+
+	ret := []Device{}
+	for _, dev := range devs {
+		name := deviceName(dev)
+		loc := locNames[dev.LocationID]
+		if loc == "" {
+			loc = dev.LocationID
+		}
+		s.mu.Lock()
+		s.locations[dev.DeviceID] = dev.LocationID
+		s.mu.Unlock()
+
+		d := Device{
+			ID:               dev.DeviceID,
+			Name:             name,
+			LocationID:       dev.LocationID,
+			Location:         loc,
+			Type:             dev.Type,
+			Manufacturer:     dev.ManufacturerName,
+			ManufacturerCode: dev.ManufacturerCode,
+			Room:             roomNames[dev.RoomID],
+		}
+		if dev.DTH != nil {
+			d.DeviceType = dev.DTH.DeviceTypeName
+		}
+		if len(dev.Components) > 0 {
+			d.Capabilities = map[string][]string{}
+			for _, comp := range dev.Components {
+				for _, c := range comp.Capabilities {
+					d.Capabilities[comp.ID] = append(d.Capabilities[comp.ID], c.ID)
+				}
+			}
+		}
+		if hub := dev.HubID(); hub != "" {
+			d.Hub = names[hub]
+			if d.Hub == "" {
+				d.Hub = hub
+			}
+		}
+		switch {
+		case dev.OCF != nil:
+			d.Model, d.Firmware = dev.OCF.ModelNumber, dev.OCF.FirmwareVersion
+			if dev.OCF.ManufacturerName != "" {
+				d.Manufacturer = dev.OCF.ManufacturerName
+			}
+		case dev.Viper != nil:
+			d.Model, d.Firmware = dev.Viper.ModelName, dev.Viper.SwVersion
+			if dev.Viper.ManufacturerName != "" {
+				d.Manufacturer = dev.Viper.ManufacturerName
+			}
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+func (s *V1Source) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	status, err := s.client.DeviceStatus(ctx, dev.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading device status: %w", err)
+	}
+	// Temperatures without a unit use the temperature scale of the location.
+	s.mu.Lock()
+	scale := s.scales[s.locations[dev.ID]]
+	s.mu.Unlock()
+
+	// Flatten the attributes of all capabilities of all components.
+	ret := map[string]interface{}{}
+	for component, caps := range status.Components {
+		for capID, attrs := range caps {
+			custom := strings.Contains(capID, ".")
+			if custom {
+				if !s.CustomCapabilities || !s.registerCapability(ctx, capID) {
+					continue
+				}
+			}
+			for name, state := range attrs {
+				if custom {
+					name = capID + "." + name
+				}
+				key := AttributeKey(component, name)
+				unit := state.Unit
+				if attr, ok := lookupAttribute(name); ok && attr.temperature && unit == "" {
+					unit = scale
+				}
+				t, err := time.Parse(time.RFC3339, state.Timestamp)
+				if err != nil && unit == "" {
+					ret[key] = state.Value
+					continue
+				}
+				ret[key] = Timestamped{Value: state.Value, Time: t, Unit: unit}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// deviceName returns the user assigned name of a device, falling back to the
+// device name.
+func deviceName(dev smartthings.Device) string {
+	if dev.Label != "" {
+		return dev.Label
+	}
+	return dev.Name
+}
+
+// loadScale fetches the temperature scale of a location, unless already
+// known. Errors are ignored, leaving the scale unknown until the next call.
+func (s *V1Source) loadScale(ctx context.Context, locationID string) {
+	s.mu.Lock()
+	_, ok := s.scales[locationID]
+	s.mu.Unlock()
+	if ok {
+		return
+	}
+	loc, err := s.client.Location(ctx, locationID)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.scales[locationID] = loc.TemperatureScale
+	s.mu.Unlock()
+}
+
+// registerCapability registers all numeric and enum attributes of a custom
+// capability, fetching its definition the first time it is seen. It returns
+// false if the definition cannot be fetched.
+func (s *V1Source) registerCapability(ctx context.Context, id string) bool {
+	s.mu.Lock()
+	ok := s.caps[id]
+	s.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	// Only the first version of custom capabilities is used in practice.
+	capability, err := s.client.Capability(ctx, id, 1)
+	if err != nil {
+		return false
+	}
+	for name, attr := range capability.Attributes {
+		schema := attr.Schema.Properties.Value
+		help := fmt.Sprintf("Value of the %s attribute of the %s capability.", name, id)
+		switch {
+		case schema.Type == "number" || schema.Type == "integer":
+			Register(id+"."+name, "", help, Converter(ValueFloat))
+		case schema.Type == "string" && len(schema.Enum) > 0:
+			values := map[string]float64{}
+			for n, v := range schema.Enum {
+				values[v] = float64(n)
+			}
+			help = fmt.Sprintf("%s Values: %s.", help, enumHelp(schema.Enum))
+			Register(id+"."+name, "", help, mapOf(values))
+		}
+	}
+
+	s.mu.Lock()
+	s.caps[id] = true
+	s.mu.Unlock()
+	return true
+}
+
+// enumHelp returns the description of the numeric values of an enum, e.g.
+// "off (0), on (1)".
+func enumHelp(enum []string) string {
+	ret := []string{}
+	for n, v := range enum {
+		ret = append(ret, fmt.Sprintf("%s (%d)", v, n))
+	}
+	return strings.Join(ret, ", ")
+}
@@ -0,0 +1,44 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// timeoutSource wraps a Source, limiting the duration of each request.
+type timeoutSource struct {
+	src     Source
+	timeout time.Duration
+}
+
+// NewTimeoutSource returns a Source that cancels requests to src taking
+// longer than timeout (if positive; like smartthings.Client, zero means no
+// timeout, and src is returned as is.) When combined with NewRetrySource, wrap the timeout
+// source with the retry source so each attempt gets its own timeout.
+func NewTimeoutSource(src Source, timeout time.Duration) Source {
+	if timeout <= 0 {
+		return src
+	}
+	return &timeoutSource{
+		src:     src,
+		timeout: timeout,
+	}
+}
+
+func (s *timeoutSource) Devices(ctx context.Context) ([]Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.src.Devices(ctx)
+}
+
+func (s *timeoutSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.src.Attributes(ctx, dev)
+}
@@ -0,0 +1,53 @@
+// SmartThings device data collection.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// slowSource is a Source whose requests take delay, or fail with the context
+// error if canceled first.
+type slowSource struct {
+	delay time.Duration
+}
+
+func (s slowSource) Devices(ctx context.Context) ([]Device, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+		return []Device{{ID: "a"}}, nil
+	}
+}
+
+func (s slowSource) Attributes(ctx context.Context, dev Device) (map[string]interface{}, error) {
+	if _, err := s.Devices(ctx); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{}, nil
+}
+
+func TestTimeoutSource(t *testing.T) {
+	casetests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{name: "no timeout", timeout: 0},
+		{name: "within timeout", timeout: time.Second},
+		{name: "timed out", timeout: time.Millisecond, wantErr: true},
+	}
+	for _, tt := range casetests {
+		src := NewTimeoutSource(slowSource{delay: 20 * time.Millisecond}, tt.timeout)
+		if _, err := src.Devices(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Devices error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
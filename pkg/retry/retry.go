@@ -0,0 +1,96 @@
+// Retry with exponential backoff.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+// Package retry implements retries with exponential backoff and jitter.
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MaxDelay is the maximum delay between two attempts.
+const MaxDelay = time.Minute
+
+// Do calls fn until it succeeds, up to 1 + retries times. The delay between
+// attempts starts at delay and doubles after every failure (up to MaxDelay),
+// with a random jitter of up to 50% to avoid synchronized retries. Do returns
+// the last error if all attempts fail, or the context error if ctx is done
+// while waiting. If the error (or any error it wraps) has a RetryAfter method
+// returning a longer delay (e.g., from an HTTP Retry-After header), that delay
+// is used instead. Errors wrapped with Permanent are returned (unwrapped)
+// without further attempts.
+func Do(ctx context.Context, retries int, delay time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if attempt >= retries {
+			break
+		}
+
+		// Jitter: wait between 50% and 100% of the current delay.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if ra := RetryAfter(err); ra > wait {
+			wait = ra
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if delay > MaxDelay {
+			delay = MaxDelay
+		}
+	}
+	if retries > 0 {
+		return fmt.Errorf("%w (giving up after %d attempts)", err, retries+1)
+	}
+	return err
+}
+
+// RetryAfter returns the delay requested by err (or any error it wraps)
+// through a RetryAfter method, or zero.
+func RetryAfter(err error) time.Duration {
+	var ra interface {
+		RetryAfter() time.Duration
+	}
+	if errors.As(err, &ra) {
+		return ra.RetryAfter()
+	}
+	return 0
+}
+
+// permanentError wraps an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so Do returns it immediately, without retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
@@ -0,0 +1,95 @@
+// Retry with exponential backoff.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// retryAfterError is an error requesting a delay before the next attempt.
+type retryAfterError time.Duration
+
+func (e retryAfterError) Error() string             { return "slow down" }
+func (e retryAfterError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestDo(t *testing.T) {
+	errFail := errors.New("failed")
+	casetests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantCalls int
+		wantErr   error
+		minTime   time.Duration
+	}{
+		{
+			name:      "success",
+			retries:   3,
+			wantCalls: 1,
+		},
+		{
+			name:      "success after failures",
+			retries:   3,
+			errs:      []error{errFail, errFail},
+			wantCalls: 3,
+		},
+		{
+			name:      "give up",
+			retries:   2,
+			errs:      []error{errFail, errFail, errFail, errFail},
+			wantCalls: 3,
+			wantErr:   errFail,
+		},
+		{
+			name:      "permanent",
+			retries:   3,
+			errs:      []error{Permanent(errFail)},
+			wantCalls: 1,
+			wantErr:   errFail,
+		},
+		{
+			name:      "retry after",
+			retries:   1,
+			errs:      []error{retryAfterError(50 * time.Millisecond)},
+			wantCalls: 2,
+			minTime:   50 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range casetests {
+		calls := 0
+		start := time.Now()
+		err := Do(context.Background(), tt.retries, time.Millisecond, func() error {
+			calls++
+			if calls <= len(tt.errs) {
+				return tt.errs[calls-1]
+			}
+			return nil
+		})
+		if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if d := time.Since(start); d < tt.minTime {
+			t.Errorf("%s: took %v, want at least %v", tt.name, d, tt.minTime)
+		}
+	}
+}
+
+func TestDoContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Do(ctx, 3, time.Hour, func() error { return errors.New("failed") })
+	if err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,126 @@
+// Output sinks for collected metrics.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+// Package sink defines the interface implemented by all smartcollector
+// outputs, and a registry of the available outputs.
+package sink
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// Sample is a metric collected at a given time.
+type Sample struct {
+	collector.Metric
+
+	// Collected is the time the metric was collected. All samples of the
+	// same collection share the same time. Metric.Time (when set) is the
+	// time SmartThings last updated the attribute.
+	Collected time.Time
+}
+
+// Sink is a destination for collected samples. Write is called once per
+// collection with all samples, and may be called concurrently with other
+// sinks (but not with itself.) Sinks are opened once and reused for all
+// collections; sinks holding resources (e.g., connections) also implement
+// io.Closer, and are closed when no longer needed.
+type Sink interface {
+	Write(ctx context.Context, samples []Sample) error
+}
+
+// Func adapts a plain function to a Sink.
+type Func func(ctx context.Context, samples []Sample) error
+
+// Write calls f.
+func (f Func) Write(ctx context.Context, samples []Sample) error {
+	return f(ctx, samples)
+}
+
+// Factory returns a configured sink, or nil if the sink is not enabled
+// (e.g., the flags or settings it needs are not set.)
+type Factory func() (Sink, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a sink available under name, replacing any sink previously
+// registered with the same name. Register is meant to be called during
+// initialization (e.g., from the init function of the package implementing
+// the sink.)
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = f
+}
+
+// Names returns the names of all registered sinks, in lexical order.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open calls the factories of the named sinks (or of all registered sinks,
+// if no names are given), and returns the enabled sinks by name. Names not
+// registered are ignored.
+func Open(names ...string) (map[string]Sink, error) {
+	if len(names) == 0 {
+		names = Names()
+	}
+	sinks := map[string]Sink{}
+	for _, name := range names {
+		registryMu.RLock()
+		f := registry[name]
+		registryMu.RUnlock()
+		if f == nil {
+			continue
+		}
+		s, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if s != nil {
+			sinks[name] = s
+		}
+	}
+	return sinks, nil
+}
+
+// Samples returns the samples for metrics collected at time t.
+func Samples(ts []collector.Metric, t time.Time) []Sample {
+	samples := make([]Sample, 0, len(ts))
+	for _, m := range ts {
+		samples = append(samples, Sample{Metric: m, Collected: t})
+	}
+	return samples
+}
+
+// Metrics returns the metrics in samples, and their collection time (the
+// collection time of the first sample, or the zero time if there are no
+// samples.)
+func Metrics(samples []Sample) ([]collector.Metric, time.Time) {
+	ts := make([]collector.Metric, 0, len(samples))
+	var t time.Time
+	for n, s := range samples {
+		if n == 0 {
+			t = s.Collected
+		}
+		ts = append(ts, s.Metric)
+	}
+	return ts, t
+}
@@ -0,0 +1,70 @@
+// Output sinks for collected metrics.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package sink
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestOpen(t *testing.T) {
+	enabled := func() (Sink, error) {
+		return Func(func(ctx context.Context, samples []Sample) error { return nil }), nil
+	}
+	Register("a", enabled)
+	Register("b", enabled)
+	Register("disabled", func() (Sink, error) { return nil, nil })
+	Register("broken", func() (Sink, error) { return nil, errors.New("broken") })
+
+	casetests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "all sinks",
+			wantErr: true,
+		},
+		{
+			name:  "named sinks",
+			names: []string{"a", "disabled"},
+			want:  []string{"a"},
+		},
+		{
+			name:  "unregistered names",
+			names: []string{"b", "unknown"},
+			want:  []string{"b"},
+		},
+		{
+			name:    "broken sink",
+			names:   []string{"a", "broken"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		sinks, err := Open(tt.names...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Open error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got := []string{}
+		for name := range sinks {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Open returned %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// SmartThings Cloud REST API client.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package smartthings
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter limits the rate of HTTP requests using a token bucket. When the
+// server asks us to slow down (with a Retry-After header in an error
+// response), all requests are paused for that long.
+type RateLimiter struct {
+	limiter *rate.Limiter
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rps requests per second.
+func NewRateLimiter(rps float64) *RateLimiter {
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+}
+
+// Wait blocks until the next request is allowed.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	pause := time.Until(l.pauseUntil)
+	l.mu.Unlock()
+
+	if pause > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// pause delays all future requests by d.
+func (l *RateLimiter) pause(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t := time.Now().Add(d); t.After(l.pauseUntil) {
+		l.pauseUntil = t
+	}
+}
+
+// Transport returns an http.RoundTripper sending requests through rt (or
+// http.DefaultTransport if nil) at the rate allowed by l. Use it to limit
+// HTTP clients other than Client, which waits for l directly when its
+// Limiter field is set.
+func (l *RateLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &rateLimitTransport{limiter: l, rt: rt}
+}
+
+// rateLimitTransport is an http.RoundTripper waiting for a RateLimiter
+// before each request.
+type rateLimitTransport struct {
+	limiter *RateLimiter
+	rt      http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		t.limiter.pause(parseRetryAfter(resp.Header.Get("Retry-After")))
+	}
+	return resp, err
+}
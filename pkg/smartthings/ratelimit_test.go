@@ -0,0 +1,74 @@
+// SmartThings Cloud REST API client.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package smartthings
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request is throttled.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"items":[]}`))
+	}))
+	defer server.Close()
+
+	casetests := []struct {
+		name string
+		get  func(l *RateLimiter) error
+	}{
+		{
+			name: "client",
+			get: func(l *RateLimiter) error {
+				c := NewClient("token")
+				c.BaseURL = server.URL
+				c.Limiter = l
+				_, err := c.Devices(context.Background())
+				return err
+			},
+		},
+		{
+			name: "transport",
+			get: func(l *RateLimiter) error {
+				c := &http.Client{Transport: l.Transport(nil)}
+				resp, err := c.Get(server.URL)
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				return nil
+			},
+		},
+	}
+	for _, tt := range casetests {
+		atomic.StoreInt32(&requests, 0)
+		l := NewRateLimiter(100)
+		tt.get(l)
+
+		start := time.Now()
+		if err := tt.get(l); err != nil {
+			t.Errorf("%s: request failed: %v", tt.name, err)
+		}
+		if d := time.Since(start); d < 900*time.Millisecond {
+			t.Errorf("%s: request after HTTP 429 was sent after %v, want at least 1s", tt.name, d)
+		}
+		if n := atomic.LoadInt32(&requests); n != 2 {
+			t.Errorf("%s: server got %d requests, want 2", tt.name, n)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+// SmartThings Cloud REST API client.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+// Package smartthings implements a minimal client for the SmartThings Cloud
+// REST API (https://api.smartthings.com), authenticated with a Personal
+// Access Token.
+package smartthings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultBaseURL is the base URL of the SmartThings API.
+const DefaultBaseURL = "https://api.smartthings.com/v1"
+
+// Client is a SmartThings API client.
+type Client struct {
+	// BaseURL is the base URL for all API requests (DefaultBaseURL by default.)
+	BaseURL string
+
+	// HTTPClient is the HTTP client used for requests (http.DefaultClient by
+	// default.)
+	HTTPClient *http.Client
+
+	// Timeout, if not zero, limits the duration of each request.
+	Timeout time.Duration
+
+	// Limiter, if not nil, limits the rate of requests. Time spent waiting
+	// for the limiter does not count towards Timeout.
+	Limiter *RateLimiter
+
+	token string
+}
+
+// Device holds the description of a device, as returned by the devices
+// endpoint.
+type Device struct {
+	DeviceID         string      `json:"deviceId"`
+	Name             string      `json:"name"`
+	Label            string      `json:"label"`
+	ManufacturerName string      `json:"manufacturerName"`
+	ManufacturerCode string      `json:"deviceManufacturerCode"`
+	LocationID       string      `json:"locationId"`
+	RoomID           string      `json:"roomId"`
+	Components       []Component `json:"components"`
+
+	// Type is the integration type of the device (e.g. "ZIGBEE", "ZWAVE",
+	// "OCF" or "VIPER".)
+	Type string `json:"type"`
+
+	// Information reported by OCF devices (e.g. Samsung appliances) and
+	// devices connected through cloud integrations (Viper), if any.
+	OCF   *OCFInfo   `json:"ocf,omitempty"`
+	Viper *ViperInfo `json:"viper,omitempty"`
+
+	// Device handler of devices using Groovy device type handlers.
+	DTH *DTHInfo `json:"dth,omitempty"`
+
+	// Protocol specific information of devices paired to a hub.
+	Zigbee    *HubDevice `json:"zigbee,omitempty"`
+	ZWave     *HubDevice `json:"zwave,omitempty"`
+	LAN       *HubDevice `json:"lan,omitempty"`
+	Matter    *HubDevice `json:"matter,omitempty"`
+	EdgeChild *HubDevice `json:"edgeChild,omitempty"`
+}
+
+// DTHInfo holds the device type handler of a device.
+type DTHInfo struct {
+	DeviceTypeName string `json:"deviceTypeName"`
+}
+
+// HubDevice holds the hub a device is paired to.
+type HubDevice struct {
+	HubID string `json:"hubId"`
+}
+
+// HubID returns the device ID of the hub the device is paired to, or an
+// empty string if the device is not connected through a hub.
+func (d Device) HubID() string {
+	for _, h := range []*HubDevice{d.Zigbee, d.ZWave, d.LAN, d.Matter, d.EdgeChild} {
+		if h != nil && h.HubID != "" {
+			return h.HubID
+		}
+	}
+	return ""
+}
+
+// OCFInfo holds the information reported by OCF devices.
+type OCFInfo struct {
+	ManufacturerName string `json:"manufacturerName"`
+	ModelNumber      string `json:"modelNumber"`
+	FirmwareVersion  string `json:"firmwareVersion"`
+}
+
+// ViperInfo holds the information reported by devices connected through
+// cloud integrations.
+type ViperInfo struct {
+	ManufacturerName string `json:"manufacturerName"`
+	ModelName        string `json:"modelName"`
+	SwVersion        string `json:"swVersion"`
+}
+
+// Location holds the description of a location.
+type Location struct {
+	LocationID string `json:"locationId"`
+	Name       string `json:"name"`
+
+	// Temperature scale used in the location ("F" or "C"). Only returned
+	// by Location.
+	TemperatureScale string `json:"temperatureScale,omitempty"`
+}
+
+// Mode holds the description of a location mode (e.g. Home, Away or Night.)
+type Mode struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+// DisplayName returns the user assigned name of the mode, falling back to
+// the mode name.
+func (m Mode) DisplayName() string {
+	if m.Label != "" {
+		return m.Label
+	}
+	return m.Name
+}
+
+// Scene holds the description of a scene.
+type Scene struct {
+	SceneID   string `json:"sceneId"`
+	SceneName string `json:"sceneName"`
+}
+
+// Rule holds the description of an automation rule.
+type Rule struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// InstalledApp holds the description of a SmartApp installed in a location.
+type InstalledApp struct {
+	InstalledAppID     string `json:"installedAppId"`
+	DisplayName        string `json:"displayName"`
+	InstalledAppType   string `json:"installedAppType"`
+	InstalledAppStatus string `json:"installedAppStatus"`
+}
+
+// Room holds the description of a room (a group of devices in a location.)
+type Room struct {
+	RoomID     string `json:"roomId"`
+	LocationID string `json:"locationId"`
+	Name       string `json:"name"`
+}
+
+// Component is a logical part of a device (e.g. one outlet of a power strip.)
+// Simple devices have a single component named "main".
+type Component struct {
+	ID           string          `json:"id"`
+	Label        string          `json:"label"`
+	Capabilities []CapabilityRef `json:"capabilities"`
+}
+
+// CapabilityRef references a specific version of a capability.
+type CapabilityRef struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+}
+
+// DeviceStatus holds the current state of all attributes of a device, indexed
+// by component ID, capability ID and attribute name.
+type DeviceStatus struct {
+	Components map[string]map[string]map[string]AttributeState `json:"components"`
+}
+
+// AttributeState holds the current value of a device attribute.
+type AttributeState struct {
+	Value     interface{} `json:"value"`
+	Unit      string      `json:"unit,omitempty"`
+	Timestamp string      `json:"timestamp,omitempty"`
+}
+
+// DeviceHealth holds the connectivity state of a device.
+type DeviceHealth struct {
+	DeviceID        string `json:"deviceId"`
+	State           string `json:"state"`
+	LastUpdatedDate string `json:"lastUpdatedDate,omitempty"`
+}
+
+// Capability holds the definition of a capability.
+type Capability struct {
+	ID         string                         `json:"id"`
+	Version    int                            `json:"version"`
+	Name       string                         `json:"name"`
+	Status     string                         `json:"status"`
+	Attributes map[string]CapabilityAttribute `json:"attributes"`
+}
+
+// CapabilityAttribute holds the definition of a capability attribute.
+type CapabilityAttribute struct {
+	Schema struct {
+		Properties struct {
+			Value AttributeSchema `json:"value"`
+		} `json:"properties"`
+	} `json:"schema"`
+}
+
+// AttributeSchema describes the type of an attribute value.
+type AttributeSchema struct {
+	Type    string   `json:"type"`
+	Enum    []string `json:"enum,omitempty"`
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+}
+
+// Subscription describes an event subscription for an installed SmartApp.
+type Subscription struct {
+	SourceType string                  `json:"sourceType"`
+	Capability *CapabilitySubscription `json:"capability,omitempty"`
+}
+
+// CapabilitySubscription subscribes to events from all devices in a location
+// supporting a given capability.
+type CapabilitySubscription struct {
+	LocationID       string `json:"locationId"`
+	Capability       string `json:"capability"`
+	Attribute        string `json:"attribute"`
+	Value            string `json:"value"`
+	StateChangeOnly  bool   `json:"stateChangeOnly"`
+	SubscriptionName string `json:"subscriptionName"`
+}
+
+// Error is returned for unsuccessful API responses.
+type Error struct {
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int
+	Body       string
+
+	retryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Body)
+}
+
+// RetryAfter returns how long the server asked us to wait before retrying
+// (from the Retry-After header), or zero.
+func (e *Error) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// NewClient returns a new client authenticated with a Personal Access Token.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: http.DefaultClient,
+		token:      token,
+	}
+}
+
+// Devices returns the list of all devices visible with the token.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	ret := []Device{}
+	err := c.getList(ctx, c.BaseURL+"/devices", func(items json.RawMessage) error {
+		page := []Device{}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+// Locations returns the list of all locations visible with the token.
+func (c *Client) Locations(ctx context.Context) ([]Location, error) {
+	page := struct {
+		Items []Location `json:"items"`
+	}{}
+	if err := c.get(ctx, c.BaseURL+"/locations", &page); err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// Location returns the full description of a location.
+func (c *Client) Location(ctx context.Context, locationID string) (*Location, error) {
+	ret := &Location{}
+	if err := c.get(ctx, c.BaseURL+"/locations/"+url.PathEscape(locationID), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Rooms returns the list of all rooms in a location.
+func (c *Client) Rooms(ctx context.Context, locationID string) ([]Room, error) {
+	page := struct {
+		Items []Room `json:"items"`
+	}{}
+	if err := c.get(ctx, c.BaseURL+"/locations/"+url.PathEscape(locationID)+"/rooms", &page); err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// Modes returns the list of all modes of a location.
+func (c *Client) Modes(ctx context.Context, locationID string) ([]Mode, error) {
+	page := struct {
+		Items []Mode `json:"items"`
+	}{}
+	if err := c.get(ctx, c.BaseURL+"/locations/"+url.PathEscape(locationID)+"/modes", &page); err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// CurrentMode returns the current mode of a location.
+func (c *Client) CurrentMode(ctx context.Context, locationID string) (*Mode, error) {
+	ret := &Mode{}
+	if err := c.get(ctx, c.BaseURL+"/locations/"+url.PathEscape(locationID)+"/modes/current", ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Scenes returns the list of all scenes in a location.
+func (c *Client) Scenes(ctx context.Context, locationID string) ([]Scene, error) {
+	ret := []Scene{}
+	err := c.getList(ctx, c.BaseURL+"/scenes?locationId="+url.QueryEscape(locationID), func(items json.RawMessage) error {
+		page := []Scene{}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+// Rules returns the list of all rules in a location.
+func (c *Client) Rules(ctx context.Context, locationID string) ([]Rule, error) {
+	ret := []Rule{}
+	err := c.getList(ctx, c.BaseURL+"/rules?locationId="+url.QueryEscape(locationID), func(items json.RawMessage) error {
+		page := []Rule{}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+// InstalledApps returns the list of all SmartApps installed in a location.
+func (c *Client) InstalledApps(ctx context.Context, locationID string) ([]InstalledApp, error) {
+	ret := []InstalledApp{}
+	err := c.getList(ctx, c.BaseURL+"/installedapps?locationId="+url.QueryEscape(locationID), func(items json.RawMessage) error {
+		page := []InstalledApp{}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+// DeviceStatus returns the current state of all attributes of a device.
+func (c *Client) DeviceStatus(ctx context.Context, deviceID string) (*DeviceStatus, error) {
+	ret := &DeviceStatus{}
+	if err := c.get(ctx, c.BaseURL+"/devices/"+url.PathEscape(deviceID)+"/status", ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DeviceHealth returns the connectivity state (ONLINE, OFFLINE or UNKNOWN)
+// of a device.
+func (c *Client) DeviceHealth(ctx context.Context, deviceID string) (*DeviceHealth, error) {
+	ret := &DeviceHealth{}
+	if err := c.get(ctx, c.BaseURL+"/devices/"+url.PathEscape(deviceID)+"/health", ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Capability returns the definition of a specific version of a capability.
+func (c *Client) Capability(ctx context.Context, id string, version int) (*Capability, error) {
+	ret := &Capability{}
+	u := fmt.Sprintf("%s/capabilities/%s/%d", c.BaseURL, url.PathEscape(id), version)
+	if err := c.get(ctx, u, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DeleteSubscriptions removes all event subscriptions of an installed SmartApp.
+func (c *Client) DeleteSubscriptions(ctx context.Context, installedAppID string) error {
+	return c.do(ctx, "DELETE", c.BaseURL+"/installedapps/"+url.PathEscape(installedAppID)+"/subscriptions", nil, nil)
+}
+
+// CreateSubscription creates a new event subscription for an installed SmartApp.
+func (c *Client) CreateSubscription(ctx context.Context, installedAppID string, sub Subscription) error {
+	return c.do(ctx, "POST", c.BaseURL+"/installedapps/"+url.PathEscape(installedAppID)+"/subscriptions", sub, nil)
+}
+
+// getList fetches all pages of a paginated list starting at u, following the
+// "next" links until the end, and calls add with the items of each page.
+func (c *Client) getList(ctx context.Context, u string, add func(items json.RawMessage) error) error {
+	for u != "" {
+		page := struct {
+			Items json.RawMessage `json:"items"`
+			Links struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"_links"`
+		}{}
+		if err := c.get(ctx, u, &page); err != nil {
+			return err
+		}
+		if len(page.Items) > 0 {
+			if err := add(page.Items); err != nil {
+				return err
+			}
+		}
+
+		u = ""
+		if page.Links.Next != nil {
+			u = page.Links.Next.Href
+		}
+	}
+	return nil
+}
+
+// get issues a GET request to the given URL and decodes the JSON response
+// into v.
+func (c *Client) get(ctx context.Context, u string, v interface{}) error {
+	return c.do(ctx, "GET", u, nil, v)
+}
+
+// do issues a request to the given URL with body (if not nil) encoded as JSON
+// and decodes the JSON response into v (if not nil).
+func (c *Client) do(ctx context.Context, method, u string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if c.Limiter != nil {
+			c.Limiter.pause(retryAfter)
+		}
+		return &Error{
+			Method:     method,
+			URL:        u,
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(msg)),
+			retryAfter: retryAfter,
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s %s: error decoding response: %v", method, u, err)
+	}
+	return nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can be a
+// number of seconds or an HTTP date. It returns zero for empty or invalid
+// values.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+// PostgreSQL and TimescaleDB support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+
+	// PostgreSQL driver for database/sql (registered as "pgx").
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func init() {
+	sink.Register("postgres", func() (sink.Sink, error) {
+		if *flagPostgresDSN == "" {
+			return nil, nil
+		}
+		cfg := postgresConfig{
+			dsn:       *flagPostgresDSN,
+			table:     *flagPostgresTable,
+			batchSize: *flagPostgresBatchSize,
+		}
+		w, err := newPostgresWriter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return withClose(withTimeout(metricsSink(w.write)), w.db.Close), nil
+	})
+}
+
+// Number of columns in each row of the readings table.
+const postgresColumns = 7
+
+// Maximum number of rows per INSERT statement, since PostgreSQL allows at most
+// 65535 parameters per statement.
+const postgresMaxBatchSize = 65535 / postgresColumns
+
+// postgresConfig holds the settings used to write to PostgreSQL.
+type postgresConfig struct {
+	dsn   string
+	table string
+
+	// Maximum number of rows per INSERT statement.
+	batchSize int
+}
+
+// createPostgresTable creates the readings table and its index, if needed.
+// If the TimescaleDB extension is installed in the database, the table is
+// also turned into a hypertable partitioned by time.
+func createPostgresTable(ctx context.Context, db *sql.DB, table string) error {
+	name := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + name + ` (
+			time      TIMESTAMPTZ NOT NULL,
+			metric    TEXT NOT NULL,
+			device_id TEXT NOT NULL DEFAULT '',
+			device    TEXT NOT NULL DEFAULT '',
+			attribute TEXT NOT NULL DEFAULT '',
+			value     DOUBLE PRECISION NOT NULL,
+			labels    JSONB NOT NULL DEFAULT '{}'
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + pgx.Identifier{strings.Replace(table, ".", "_", -1) + "_device_metric_time"}.Sanitize() +
+			` ON ` + name + ` (device_id, metric, time DESC)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	var timescale bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&timescale); err != nil {
+		return err
+	}
+	if timescale {
+		if _, err := db.ExecContext(ctx, "SELECT create_hypertable($1::regclass, 'time', if_not_exists => TRUE, migrate_data => TRUE)", name); err != nil {
+			return fmt.Errorf("error creating hypertable: %v", err)
+		}
+	}
+	return nil
+}
+
+// postgresWriter writes metrics to PostgreSQL, through a connection pool kept
+// for all collections.
+type postgresWriter struct {
+	cfg postgresConfig
+	db  *sql.DB
+
+	// Whether the table was created (or found to exist.)
+	created bool
+}
+
+// newPostgresWriter returns a writer for the database in cfg. Connections are
+// made when first needed.
+func newPostgresWriter(cfg postgresConfig) (*postgresWriter, error) {
+	db, err := sql.Open("pgx", cfg.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresWriter{cfg: cfg, db: db}, nil
+}
+
+// write inserts the metrics collected at time t into PostgreSQL, in batches
+// of at most cfg.batchSize (and postgresMaxBatchSize) rows, creating the
+// table on the first write. All batches are inserted in a single transaction.
+func (w *postgresWriter) write(ctx context.Context, ts []collector.Metric, t time.Time) error {
+	cfg, db := w.cfg, w.db
+	if !w.created {
+		if err := createPostgresTable(ctx, db, cfg.table); err != nil {
+			return err
+		}
+		w.created = true
+	}
+
+	size := cfg.batchSize
+	if size < 1 || size > postgresMaxBatchSize {
+		size = postgresMaxBatchSize
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(ts); start += size {
+		end := start + size
+		if end > len(ts) {
+			end = len(ts)
+		}
+		stmt, args, err := postgresInsert(cfg.table, ts[start:end], t)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// postgresInsert returns a multi-row INSERT statement and its arguments for
+// the metrics collected at time t.
+func postgresInsert(table string, ts []collector.Metric, t time.Time) (string, []interface{}, error) {
+	rows := []string{}
+	args := []interface{}{}
+	for _, m := range ts {
+		rec := newJSONRecord(m, t)
+		labels, err := labelsJSON(rec)
+		if err != nil {
+			return "", nil, err
+		}
+		n := len(args)
+		placeholders := []string{}
+		for i := 1; i <= postgresColumns; i++ {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", n+i))
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, t, rec.Metric, rec.ID, rec.Name, rec.Attribute, rec.Value, labels)
+	}
+	stmt := "INSERT INTO " + pgx.Identifier(strings.Split(table, ".")).Sanitize() +
+		" (time, metric, device_id, device, attribute, value, labels) VALUES " + strings.Join(rows, ", ")
+	return stmt, args, nil
+}
@@ -0,0 +1,178 @@
+// PostgreSQL and TimescaleDB support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// fakePostgres is a database/sql driver logging the statements executed,
+// with the number of arguments of each. It reports TimescaleDB as missing,
+// and fails statements starting with failPrefix.
+type fakePostgres struct {
+	mu         sync.Mutex
+	log        []string
+	failPrefix string
+}
+
+func (d *fakePostgres) record(s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, s)
+}
+
+func (d *fakePostgres) Open(name string) (driver.Conn, error) { return fakePostgresConn{d}, nil }
+
+type fakePostgresConn struct{ d *fakePostgres }
+
+func (c fakePostgresConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements not supported")
+}
+func (c fakePostgresConn) Close() error { return nil }
+func (c fakePostgresConn) Begin() (driver.Tx, error) {
+	c.d.record("BEGIN")
+	return fakePostgresTx{c.d}, nil
+}
+
+func (c fakePostgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	fields := strings.Fields(query)
+	stmt := fmt.Sprintf("%s %s (%d)", fields[0], fields[1], len(args))
+	c.d.record(stmt)
+	c.d.mu.Lock()
+	fail := c.d.failPrefix != "" && strings.HasPrefix(stmt, c.d.failPrefix)
+	c.d.mu.Unlock()
+	if fail {
+		return nil, errors.New("statement failed")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakePostgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.record("SELECT")
+	return &fakePostgresRows{}, nil
+}
+
+type fakePostgresTx struct{ d *fakePostgres }
+
+func (tx fakePostgresTx) Commit() error   { tx.d.record("COMMIT"); return nil }
+func (tx fakePostgresTx) Rollback() error { tx.d.record("ROLLBACK"); return nil }
+
+// fakePostgresRows returns a single false value.
+type fakePostgresRows struct{ done bool }
+
+func (r *fakePostgresRows) Columns() []string { return []string{"exists"} }
+func (r *fakePostgresRows) Close() error      { return nil }
+func (r *fakePostgresRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = false
+	return nil
+}
+
+var fakePostgresDriver = &fakePostgres{}
+
+func init() {
+	sql.Register("fakepostgres", fakePostgresDriver)
+}
+
+func TestPostgresWriter(t *testing.T) {
+	db, err := sql.Open("fakepostgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	w := &postgresWriter{cfg: postgresConfig{table: "public.readings", batchSize: 2}, db: db}
+
+	ts := []collector.Metric{}
+	for n := 0; n < 5; n++ {
+		ts = append(ts, collector.Metric{Name: fmt.Sprintf("m%d", n), Value: float64(n)})
+	}
+
+	// Runs share the same writer, in order.
+	casetests := []struct {
+		name       string
+		failPrefix string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name: "first write creates the table",
+			want: []string{
+				"CREATE TABLE (0)", "CREATE INDEX (0)", "SELECT",
+				"BEGIN", "INSERT INTO (14)", "INSERT INTO (14)", "INSERT INTO (7)", "COMMIT",
+			},
+		},
+		{
+			name: "table already created",
+			want: []string{"BEGIN", "INSERT INTO (14)", "INSERT INTO (14)", "INSERT INTO (7)", "COMMIT"},
+		},
+		{
+			name:       "failed insert",
+			failPrefix: "INSERT",
+			want:       []string{"BEGIN", "INSERT INTO (14)", "ROLLBACK"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range casetests {
+		fakePostgresDriver.mu.Lock()
+		fakePostgresDriver.log, fakePostgresDriver.failPrefix = nil, tt.failPrefix
+		fakePostgresDriver.mu.Unlock()
+
+		err := w.write(context.Background(), ts, time.Now())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: write error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		fakePostgresDriver.mu.Lock()
+		if !reflect.DeepEqual(fakePostgresDriver.log, tt.want) {
+			t.Errorf("%s: statements = %q, want %q", tt.name, fakePostgresDriver.log, tt.want)
+		}
+		fakePostgresDriver.mu.Unlock()
+	}
+}
+
+func TestPostgresInsert(t *testing.T) {
+	now := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	ts := []collector.Metric{
+		{
+			Name:      "smartthings_power_watts",
+			Labels:    []collector.Label{{Name: "id", Value: "p1"}, {Name: "name", Value: "Plug"}, {Name: "room", Value: "Office"}},
+			Attribute: "power",
+			Value:     12.5,
+		},
+		{Name: "smartcollector_devices_total", Value: 1},
+	}
+
+	stmt, args, err := postgresInsert("metrics.readings", ts, now)
+	if err != nil {
+		t.Fatalf("postgresInsert failed: %v", err)
+	}
+	wantStmt := `INSERT INTO "metrics"."readings" (time, metric, device_id, device, attribute, value, labels) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`
+	if stmt != wantStmt {
+		t.Errorf("statement = %q, want %q", stmt, wantStmt)
+	}
+	wantArgs := []interface{}{
+		now, "smartthings_power_watts", "p1", "Plug", "power", 12.5, `{"room":"Office"}`,
+		now, "smartcollector_devices_total", "", "", "", 1.0, "{}",
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("arguments = %v, want %v", args, wantArgs)
+	}
+}
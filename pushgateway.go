@@ -0,0 +1,70 @@
+// Prometheus Pushgateway support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("pushgateway", func() (sink.Sink, error) {
+		if *flagPushGatewayURL == "" {
+			return nil, nil
+		}
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return pushTimeSeries(ctx, *flagPushGatewayURL, *flagPushGatewayJob, *flagPushGatewayInstance, ts)
+		})), nil
+	})
+}
+
+// pushTimeSeries sends the array of timeseries to a Prometheus Pushgateway at
+// baseURL, grouped under the given job and instance labels. Any metrics
+// previously pushed under the same grouping key are replaced.
+func pushTimeSeries(ctx context.Context, baseURL, job, instance string, ts []collector.Metric) error {
+	if job == "" {
+		return fmt.Errorf("pushgateway job name cannot be empty")
+	}
+	u := strings.TrimRight(baseURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	if instance != "" {
+		u += "/instance/" + url.PathEscape(instance)
+	}
+
+	body := &bytes.Buffer{}
+	if err := writeTimeSeries(body, ts); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Pushgateway returns 200 (older versions) or 202 on success.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from pushgateway %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
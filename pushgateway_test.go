@@ -0,0 +1,93 @@
+// Prometheus Pushgateway support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestPushTimeSeries(t *testing.T) {
+	ts := []collector.Metric{
+		{Name: "smartthings_switch_on", Labels: []collector.Label{{Name: "name", Value: "Lamp"}}, Value: 1, Help: "Whether the switch is on."},
+	}
+	want := &bytes.Buffer{}
+	if err := writeTimeSeries(want, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	casetests := []struct {
+		name     string
+		job      string
+		instance string
+		status   int
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "job and instance",
+			job:      "smartcollector",
+			instance: "host1",
+			status:   http.StatusOK,
+			wantPath: "/metrics/job/smartcollector/instance/host1",
+		},
+		{
+			name:     "escaped job, no instance",
+			job:      "smart collector/1",
+			status:   http.StatusAccepted,
+			wantPath: "/metrics/job/smart%20collector%2F1",
+		},
+		{
+			name:    "empty job",
+			wantErr: true,
+		},
+		{
+			name:     "server error",
+			job:      "smartcollector",
+			status:   http.StatusBadRequest,
+			wantPath: "/metrics/job/smartcollector",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range casetests {
+		var (
+			gotPath   string
+			gotMethod string
+			gotBody   []byte
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.EscapedPath()
+			gotMethod = r.Method
+			gotBody, _ = io.ReadAll(r.Body)
+			w.WriteHeader(tt.status)
+		}))
+
+		err := pushTimeSeries(context.Background(), server.URL+"/", tt.job, tt.instance, ts)
+		server.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: pushTimeSeries error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantPath == "" {
+			continue
+		}
+		if gotMethod != "PUT" {
+			t.Errorf("%s: method = %s, want PUT", tt.name, gotMethod)
+		}
+		if gotPath != tt.wantPath {
+			t.Errorf("%s: path = %q, want %q", tt.name, gotPath, tt.wantPath)
+		}
+		if !bytes.Equal(gotBody, want.Bytes()) {
+			t.Errorf("%s: body = %q, want %q", tt.name, gotBody, want.Bytes())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// RedisTimeSeries support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("redis", func() (sink.Sink, error) {
+		if *flagRedisURL == "" {
+			return nil, nil
+		}
+		opts, err := redis.ParseURL(*flagRedisURL)
+		if err != nil {
+			return nil, err
+		}
+		rdb := redis.NewClient(opts)
+		return withClose(withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeRedisTimeSeries(ctx, rdb, *flagRedisKeyPrefix, *flagRedisRetention, ts, t)
+		})), rdb.Close), nil
+	})
+}
+
+// redisKey returns the RedisTimeSeries key for a metric: the prefix, metric
+// name, device ID and the values of other labels describing the value (like
+// component), separated by colons (e.g.
+// smartthings:smartthings_temperature_fahrenheit:<id>:main).
+func redisKey(prefix string, m collector.Metric) string {
+	parts := []string{prefix, m.Name}
+	if id := m.Label("id"); id != "" {
+		parts = append(parts, id)
+	}
+	for _, l := range m.Labels {
+		if l.Value != "" && !contains(deviceLabelNames, l.Name) {
+			parts = append(parts, l.Value)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// redisAddArgs returns the TS.ADD command adding the value of a metric at time
+// t. Keys are created as needed with the given retention (zero keeps samples
+// forever) and the metric name and non-empty labels as series labels.
+// Samples with the same timestamp as an existing one replace it.
+func redisAddArgs(prefix string, retention time.Duration, m collector.Metric, t time.Time) []interface{} {
+	args := []interface{}{
+		"TS.ADD", redisKey(prefix, m), t.UnixNano() / int64(time.Millisecond), m.Value,
+		"RETENTION", int64(retention / time.Millisecond),
+		"ON_DUPLICATE", "LAST",
+		"LABELS", "metric", m.Name,
+	}
+	for _, l := range m.Labels {
+		if l.Value != "" {
+			args = append(args, l.Name, l.Value)
+		}
+	}
+	return args
+}
+
+// writeRedisTimeSeries adds the metrics collected at time t to RedisTimeSeries
+// keys through rdb, in a single pipeline.
+func writeRedisTimeSeries(ctx context.Context, rdb *redis.Client, prefix string, retention time.Duration, ts []collector.Metric, t time.Time) error {
+	pipe := rdb.Pipeline()
+	cmds := []*redis.Cmd{}
+	for _, m := range ts {
+		cmds = append(cmds, pipe.Do(ctx, redisAddArgs(prefix, retention, m, t)...))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Report the first failed command, which usually tells more.
+		for n, cmd := range cmds {
+			if cmd.Err() != nil {
+				return fmt.Errorf("%s: %v", redisKey(prefix, ts[n]), cmd.Err())
+			}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// RedisTimeSeries support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+)
+
+func TestRedisAddArgs(t *testing.T) {
+	m := collector.Metric{
+		Name: "smartthings_temperature_fahrenheit",
+		Labels: []collector.Label{
+			{Name: "id", Value: "d1"},
+			{Name: "name", Value: "Fridge"},
+			{Name: "hub", Value: ""},
+			{Name: "component", Value: "main"},
+		},
+		Value: 38.5,
+	}
+	got := redisAddArgs("smartthings", 24*time.Hour, m, time.Unix(1462096800, 5e8))
+	want := []interface{}{
+		"TS.ADD", "smartthings:smartthings_temperature_fahrenheit:d1:main", int64(1462096800500), 38.5,
+		"RETENTION", int64(86400000),
+		"ON_DUPLICATE", "LAST",
+		"LABELS", "metric", "smartthings_temperature_fahrenheit",
+		"id", "d1", "name", "Fridge", "component", "main",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redisAddArgs = %v, want %v", got, want)
+	}
+}
+
+// fakeRedis is a minimal Redis server recording the TS.ADD commands sent to
+// it. Commands for keys containing "bad" fail.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// readRedisCommand reads a command sent as an array of bulk strings.
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid command header %q", line)
+	}
+	args := []string{}
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument header %q", line)
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			io.WriteString(conn, "-ERR unknown command 'HELLO'\r\n")
+		case "TS.ADD":
+			s.mu.Lock()
+			s.keys = append(s.keys, args[1])
+			s.mu.Unlock()
+			if strings.Contains(args[1], "bad") {
+				io.WriteString(conn, "-ERR TSDB: invalid value\r\n")
+				continue
+			}
+			io.WriteString(conn, ":"+args[2]+"\r\n")
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+	}
+}
+
+func TestWriteRedisTimeSeries(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.ln.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: server.ln.Addr().String()})
+	defer rdb.Close()
+
+	casetests := []struct {
+		name     string
+		ts       []collector.Metric
+		wantKeys []string
+		wantErr  string
+	}{
+		{
+			name:     "all added",
+			ts:       []collector.Metric{{Name: "m1", Value: 1}, {Name: "m2", Value: 2}},
+			wantKeys: []string{"st:m1", "st:m2"},
+		},
+		{
+			name:     "failed command",
+			ts:       []collector.Metric{{Name: "m1", Value: 1}, {Name: "bad", Value: 2}, {Name: "m3", Value: 3}},
+			wantKeys: []string{"st:m1", "st:bad", "st:m3"},
+			wantErr:  "st:bad: ERR TSDB: invalid value",
+		},
+	}
+
+	for _, tt := range casetests {
+		server.mu.Lock()
+		server.keys = nil
+		server.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := writeRedisTimeSeries(ctx, rdb, "st", 0, tt.ts, time.Now())
+		cancel()
+		if got := fmt.Sprint(err); (err != nil || tt.wantErr != "") && got != tt.wantErr {
+			t.Errorf("%s: writeRedisTimeSeries error = %q, want %q", tt.name, got, tt.wantErr)
+		}
+		server.mu.Lock()
+		if !reflect.DeepEqual(server.keys, tt.wantKeys) {
+			t.Errorf("%s: keys = %q, want %q", tt.name, server.keys, tt.wantKeys)
+		}
+		server.mu.Unlock()
+	}
+}
@@ -0,0 +1,236 @@
+// Metric relabeling for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+)
+
+// Label name holding the metric name in relabeling rules, as in Prometheus.
+const metricNameLabel = "__name__"
+
+// validLabelName matches valid Prometheus label names.
+var validLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// relabelRule is a Prometheus style relabeling rule, applied to all metrics
+// before output. See the relabel_config section of the Prometheus
+// documentation for the meaning of each field. Supported actions are replace
+// (the default), keep, drop, labelmap, labeldrop and labelkeep.
+type relabelRule struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	// Parsed versions of the fields above (with defaults), filled by
+	// validate.
+	re          *regexp.Regexp
+	separator   string
+	replacement string
+}
+
+// validate checks the rule for errors and fills the parsed fields.
+func (r *relabelRule) validate() error {
+	if r.Action == "" {
+		r.Action = "replace"
+	}
+	switch r.Action {
+	case "replace":
+		if r.TargetLabel == "" {
+			return fmt.Errorf("target_label is required with action replace")
+		}
+	case "keep", "drop":
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("source_labels are required with action %s", r.Action)
+		}
+	case "labelmap", "labeldrop", "labelkeep":
+	default:
+		return fmt.Errorf("invalid action %q (valid actions are replace, keep, drop, labelmap, labeldrop and labelkeep)", r.Action)
+	}
+	if r.TargetLabel != "" && r.TargetLabel != metricNameLabel && !validLabelName.MatchString(r.TargetLabel) {
+		return fmt.Errorf("invalid target_label %q", r.TargetLabel)
+	}
+
+	regex := "(.*)"
+	if r.Regex != nil {
+		regex = *r.Regex
+	}
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %v", regex, err)
+	}
+	r.re = re
+
+	r.separator = ";"
+	if r.Separator != nil {
+		r.separator = *r.Separator
+	}
+	r.replacement = "$1"
+	if r.Replacement != nil {
+		r.replacement = *r.Replacement
+	}
+	return nil
+}
+
+// apply applies the rule to m, returning the modified metric, or false if the
+// metric should be dropped.
+func (r *relabelRule) apply(m collector.Metric) (collector.Metric, bool) {
+	values := []string{}
+	for _, l := range r.SourceLabels {
+		values = append(values, labelValue(m, l))
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.Action {
+	case "keep":
+		return m, r.re.MatchString(value)
+	case "drop":
+		return m, !r.re.MatchString(value)
+	case "replace":
+		match := r.re.FindStringSubmatchIndex(value)
+		if match == nil {
+			return m, true
+		}
+		res := string(r.re.ExpandString(nil, r.replacement, value, match))
+		return setLabel(m, r.TargetLabel, res), true
+	case "labelmap":
+		for _, l := range m.Labels {
+			if match := r.re.FindStringSubmatchIndex(l.Name); match != nil {
+				name := string(r.re.ExpandString(nil, r.replacement, l.Name, match))
+				m = setLabel(m, name, l.Value)
+			}
+		}
+	case "labeldrop", "labelkeep":
+		labels := []collector.Label{}
+		for _, l := range m.Labels {
+			if r.re.MatchString(l.Name) == (r.Action == "labelkeep") {
+				labels = append(labels, l)
+			}
+		}
+		m.Labels = labels
+	}
+	return m, true
+}
+
+// labelValue returns the value of a label of m, or its name for
+// metricNameLabel.
+func labelValue(m collector.Metric, name string) string {
+	if name == metricNameLabel {
+		return m.Name
+	}
+	return m.Label(name)
+}
+
+// setLabel returns a copy of m with the label set to value, or the metric
+// name changed for metricNameLabel. Labels are added as needed.
+func setLabel(m collector.Metric, name, value string) collector.Metric {
+	if name == metricNameLabel {
+		m.Name = value
+		return m
+	}
+	labels := []collector.Label{}
+	found := false
+	for _, l := range m.Labels {
+		if l.Name == name {
+			l.Value, found = value, true
+		}
+		labels = append(labels, l)
+	}
+	if !found {
+		labels = append(labels, collector.Label{Name: name, Value: value})
+	}
+	m.Labels = labels
+	return m
+}
+
+// labelFlag is a repeatable command-line flag holding name=value pairs. Each
+// value may also hold several comma separated pairs.
+type labelFlag []collector.Label
+
+// String implements flag.Value.
+func (f *labelFlag) String() string {
+	pairs := []string{}
+	for _, l := range *f {
+		pairs = append(pairs, l.Name+"="+l.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (f *labelFlag) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid label %q (use name=value)", pair)
+		}
+		name, value := strings.TrimSpace(pair[:i]), pair[i+1:]
+		if !validLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		*f = append(*f, collector.Label{Name: name, Value: value})
+	}
+	return nil
+}
+
+// addDeviceLabels adds the labels configured for the device of m (see
+// config.DeviceLabels), except those already set. Patterns are checked in
+// lexical order, so the first matching pattern wins on conflicts.
+func addDeviceLabels(m collector.Metric, deviceLabels map[string]map[string]string) collector.Metric {
+	id, name := m.Label("id"), m.Label("name")
+	if id == "" {
+		return m
+	}
+	patterns := []string{}
+	for p := range deviceLabels {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+
+	for _, p := range patterns {
+		if !matchAny([]string{p}, id, name) {
+			continue
+		}
+		names := []string{}
+		for l := range deviceLabels[p] {
+			names = append(names, l)
+		}
+		sort.Strings(names)
+		for _, l := range names {
+			if labelValue(m, l) == "" {
+				m = setLabel(m, l, deviceLabels[p][l])
+			}
+		}
+	}
+	return m
+}
+
+// relabelFunc returns a function adding the device labels and applying the
+// relabeling rules in the configuration to a metric, or nil if there are
+// none.
+func relabelFunc(cfg *config) func(collector.Metric) (collector.Metric, bool) {
+	if len(cfg.Relabel) == 0 && len(cfg.DeviceLabels) == 0 {
+		return nil
+	}
+	return func(m collector.Metric) (collector.Metric, bool) {
+		if len(cfg.DeviceLabels) > 0 {
+			m = addDeviceLabels(m, cfg.DeviceLabels)
+		}
+		for n := range cfg.Relabel {
+			var ok bool
+			if m, ok = cfg.Relabel[n].apply(m); !ok {
+				return m, false
+			}
+		}
+		return m, true
+	}
+}
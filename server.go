@@ -0,0 +1,176 @@
+// HTTP exporter mode for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// Maximum time to wait for in-flight requests when shutting down.
+const shutdownTimeout = time.Minute
+
+// inflight holds the start time of the collections (scrapes or cache
+// refreshes) in progress in serve mode. The systemd watchdog is not notified
+// while one of them runs for too long, so a wedged collector gets restarted
+// no matter how often Prometheus scrapes.
+var inflight struct {
+	sync.Mutex
+	next    int
+	started map[int]time.Time
+}
+
+// startCollection records the start of a collection, and returns a function
+// to be called when it finishes.
+func startCollection() func() {
+	inflight.Lock()
+	defer inflight.Unlock()
+	if inflight.started == nil {
+		inflight.started = map[int]time.Time{}
+	}
+	id := inflight.next
+	inflight.next++
+	inflight.started[id] = time.Now()
+	return func() {
+		inflight.Lock()
+		defer inflight.Unlock()
+		delete(inflight.started, id)
+	}
+}
+
+// longestCollection returns how long the oldest collection in progress has
+// been running, or zero if there are none.
+func longestCollection() time.Duration {
+	inflight.Lock()
+	defer inflight.Unlock()
+	var longest time.Duration
+	for _, t := range inflight.started {
+		if d := time.Since(t); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// serveMetrics starts an HTTP server on addr exposing a /metrics endpoint.
+// Device data is collected from SmartThings on every scrape, and written to
+// the auxiliary sinks in aux (change notifications, state change events and
+// the snapshot archive.) The server shuts
+// down gracefully (waiting for in-flight requests) when a signal is received
+// on stop.
+//
+// The server also exposes /healthz, which fails if the SmartThings token is
+// no longer valid, and /readyz, which fails until the first collection
+// finishes and whenever the last collection failed.
+func serveMetrics(addr string, col *collector.Collector, aux map[string]sink.Sink, stop <-chan os.Signal) error {
+	auxw := newAuxiliaryWriter(aux)
+	defer auxw.close()
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		done := startCollection()
+		ts, err := col.Collect(r.Context())
+		done()
+		if err != nil {
+			slog.Error("Error collecting metrics", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sdReady()
+		saveButtons(buttonStateFile, col.Buttons)
+		auxw.write(ts, time.Now())
+		reg, err := newRegistry(ts, *flagSampleTimestamps)
+		if err != nil {
+			slog.Error("Error building metrics", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := col.Status(); isAuthError(err) {
+			http.Error(w, "token invalid: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		last, err := col.Status()
+		if last.IsZero() {
+			http.Error(w, "waiting for the first collection", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, "last collection failed: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	// Run a first collection in the background so readiness can be
+	// determined before the first scrape (and systemd notified.)
+	go func() {
+		done := startCollection()
+		ts, err := col.Collect(context.Background())
+		done()
+		if err == nil {
+			sdReady()
+			auxw.write(ts, time.Now())
+		}
+	}()
+
+	// Notify the systemd watchdog unless a collection is taking longer than
+	// WatchdogSec, so a wedged collector gets restarted. The interval is half
+	// of WatchdogSec.
+	if wd := sdWatchdogInterval(); wd > 0 {
+		go func() {
+			for range time.Tick(wd) {
+				if longestCollection() < 2*wd {
+					sdNotify("WATCHDOG=1")
+				}
+			}
+		}()
+	}
+
+	srv := &http.Server{Addr: addr}
+	errch := make(chan error, 1)
+	go func() {
+		errch <- srv.ListenAndServe()
+	}()
+	slog.Info("Listening", "addr", addr)
+
+	select {
+	case err := <-errch:
+		return err
+	case sig := <-stop:
+		slog.Info("Shutting down", "signal", sig.String())
+		sdNotify("STOPPING=1")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// isAuthError returns true if err indicates an invalid or expired token.
+func isAuthError(err error) bool {
+	var apiErr *smartthings.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+	}
+	var oauthErr *oauth2.RetrieveError
+	return errors.As(err, &oauthErr)
+}
@@ -0,0 +1,34 @@
+// HTTP exporter mode for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLongestCollection(t *testing.T) {
+	if d := longestCollection(); d != 0 {
+		t.Fatalf("longestCollection with no collections = %v, want 0", d)
+	}
+
+	first := startCollection()
+	time.Sleep(20 * time.Millisecond)
+	second := startCollection()
+	if d := longestCollection(); d < 20*time.Millisecond {
+		t.Errorf("longestCollection = %v, want at least 20ms", d)
+	}
+
+	// Only collections still in progress count.
+	first()
+	if d := longestCollection(); d >= 20*time.Millisecond {
+		t.Errorf("longestCollection after the oldest finished = %v, want less than 20ms", d)
+	}
+	second()
+	if d := longestCollection(); d != 0 {
+		t.Errorf("longestCollection after all finished = %v, want 0", d)
+	}
+}
@@ -14,12 +14,19 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/marcopaganini/gosmart"
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
 	"golang.org/x/net/context"
 )
 
@@ -39,196 +46,540 @@ const (
 var (
 	flagClient               = flag.String("client", "", "OAuth Client ID")
 	flagSecret               = flag.String("secret", "", "OAuth Secret")
-	flagTextFileCollectorDir = flag.String("textfile-dir", textFileCollectorDir, "Textfile Collector directory")
+	flagTextFileCollectorDir = flag.String("textfile-dir", textFileCollectorDir, "Textfile Collector directory (setting it also writes the textfile when other outputs are set)")
 	flagDryRun               = flag.Bool("dry-run", false, "Just print the values (don't save to file)")
+	flagOutput               = flag.String("output", "text", "Output format for --dry-run: text, json or csv")
+	flagListen               = flag.String("listen", ":9299", "Address to serve metrics on (serve command)")
+	flagPushGatewayURL       = flag.String("pushgateway-url", "", "Push metrics to this Prometheus Pushgateway URL")
+	flagPushGatewayJob       = flag.String("pushgateway-job", "smartcollector", "Job label used when pushing to the Pushgateway")
+	flagPushGatewayInstance  = flag.String("pushgateway-instance", hostname(), "Instance label used when pushing to the Pushgateway")
+	flagInfluxDBURL          = flag.String("influxdb-url", "", "Write metrics to this InfluxDB 2.x URL")
+	flagInfluxDBOrg          = flag.String("influxdb-org", "", "InfluxDB organization")
+	flagInfluxDBBucket       = flag.String("influxdb-bucket", "smartthings", "InfluxDB bucket")
+	flagInfluxDBToken        = flag.String("influxdb-token", "", "InfluxDB API token")
+	flagInfluxDBBatchSize    = flag.Int("influxdb-batch-size", 5000, "Maximum number of points per InfluxDB write request")
+	flagGraphiteAddr         = flag.String("graphite-addr", "", "Send metrics to this Graphite (Carbon) plaintext address (host:port)")
+	flagGraphitePrefix       = flag.String("graphite-prefix", "smartthings", "Prefix for all Graphite metric paths")
+	flagOTLPEndpoint         = flag.String("otlp-endpoint", "", "Export metrics to this OpenTelemetry (OTLP) endpoint (host:port for grpc, URL for http)")
+	flagOTLPProtocol         = flag.String("otlp-protocol", "grpc", "OTLP protocol: grpc or http")
+	flagOTLPInsecure         = flag.Bool("otlp-insecure", false, "Use plaintext (no TLS) OTLP gRPC connections")
+	flagStatsDAddr           = flag.String("statsd-addr", "", "Send metrics as gauges to this StatsD/DogStatsD address (host:port, UDP)")
+	flagKafkaBrokers         = flag.String("kafka-brokers", "", "Publish metrics to these Kafka brokers (comma separated host:port list)")
+	flagKafkaTopic           = flag.String("kafka-topic", "smartthings", "Kafka topic to publish metrics to")
+	flagVictoriaMetricsURL   = flag.String("victoriametrics-url", "", "Import metrics to this VictoriaMetrics URL")
+	flagJSONLinesFile        = flag.String("jsonl-file", "", "Append metrics as JSON Lines to this file (- for stdout)")
+	flagCSVFile              = flag.String("csv-file", "", "Append metrics as CSV rows to this file (- for stdout)")
+	flagSQLiteDB             = flag.String("sqlite-db", "", "Record metrics in this SQLite database file (created if needed)")
+	flagPostgresDSN          = flag.String("postgres-dsn", "", "Insert metrics into the PostgreSQL (or TimescaleDB) database at this URL or DSN")
+	flagPostgresTable        = flag.String("postgres-table", "smartthings_readings", "PostgreSQL table (optionally schema.table) to insert metrics into, created if needed")
+	flagPostgresBatchSize    = flag.Int("postgres-batch-size", 1000, "Maximum number of rows per PostgreSQL INSERT statement (at most 9362)")
+	flagCloudWatchNamespace  = flag.String("cloudwatch-namespace", "", "Publish metrics as AWS CloudWatch custom metrics in this namespace (e.g. SmartThings)")
+	flagCloudWatchDimensions = flag.String("cloudwatch-dimensions", "", "Comma separated list of labels used as CloudWatch dimensions (default: all)")
+	flagGCMProject           = flag.String("gcm-project", "", "Write metrics as Google Cloud Monitoring custom metrics in this project ID")
+	flagGCMMetricPrefix      = flag.String("gcm-metric-prefix", "custom.googleapis.com/smartthings/", "Prefix for Google Cloud Monitoring metric types")
+	flagZabbixAddr           = flag.String("zabbix-addr", "", "Send metrics to this Zabbix server or proxy address (host:port, usually port 10051) using the sender protocol")
+	flagZabbixHost           = flag.String("zabbix-host", hostname(), "Zabbix host receiving the values sent with --zabbix-addr")
+	flagHomeAssistantURL     = flag.String("homeassistant-url", "", "Create and update sensors for all device attributes in the Home Assistant instance at this URL")
+	flagHomeAssistantToken   = flag.String("homeassistant-token", "", "Home Assistant long-lived access token")
+	flagNATSURL              = flag.String("nats-url", "", "Publish metrics to these NATS servers (comma separated nats:// URLs)")
+	flagNATSSubjectPrefix    = flag.String("nats-subject-prefix", "smartthings", "Prefix for NATS subjects")
+	flagRedisURL             = flag.String("redis-url", "", "Add metrics to RedisTimeSeries keys in the Redis server at this URL (redis://[:password@]host:port/db)")
+	flagRedisKeyPrefix       = flag.String("redis-key-prefix", "smartthings", "Prefix for RedisTimeSeries keys")
+	flagRedisRetention       = flag.Duration("redis-retention", 0, "Retention of RedisTimeSeries keys created by smartcollector (e.g. 720h). Zero keeps samples forever")
+	flagNotifyURL            = flag.String("notify-url", "", "POST a JSON event to this URL whenever a notification condition in the configuration file changes")
+	flagEvents               = flag.String("events", "", "Log a message to syslog or journal whenever the state of a sensor changes (syslog or journal)")
+	flagEventAttributes      = flag.String("event-attributes", "contact,motion,presence,water,smoke,carbonMonoxide,acceleration,tamper", "Comma separated list of attributes whose state changes are logged with --events")
+	flagArchiveDir           = flag.String("archive-dir", "", "Keep a gzip compressed copy of every textfile snapshot in this directory")
+	flagArchiveMaxAge        = flag.Duration("archive-max-age", 0, "Remove archived snapshots older than this (e.g. 720h). Zero keeps them forever")
+	flagArchiveMaxSize       = flag.Int64("archive-max-size", 0, "Remove the oldest archived snapshots when the archive grows above this size, in MiB. Zero means no limit")
+	flagInterval             = flag.Duration("interval", 0, "Keep running and collect at this interval (e.g. 5m) instead of exiting after one run. With --webhook, poll all devices at this interval to reconcile missed events")
+	flagConfig               = flag.String("config", "", "YAML configuration file")
+	flagAPI                  = flag.String("api", "legacy", "SmartThings API to use: v1 (REST API with Personal Access Token) or legacy (SmartApp with OAuth)")
+	flagToken                = flag.String("token", "", "SmartThings Personal Access Token (v1 API)")
+	flagMaxConcurrency       = flag.Int("max-concurrency", 4, "Maximum number of devices to fetch concurrently")
+	flagRetries              = flag.Int("retries", 3, "Number of times to retry failed SmartThings API requests")
+	flagRetryDelay           = flag.Duration("retry-delay", time.Second, "Initial delay between retries (doubles after every failure)")
+	flagStrict               = flag.Bool("strict", false, "Abort on the first device error instead of skipping the device")
+	flagTimeout              = flag.Duration("timeout", 30*time.Second, "Timeout for each request to SmartThings and other remote services")
+	flagDeviceCacheTTL       = flag.Duration("device-cache-ttl", 0, "Cache the device list (and endpoint URI) on disk for this long (e.g. 1h). Zero disables the cache")
+	flagRefreshDevices       = flag.Bool("refresh-devices", false, "Ignore the device cache and fetch the device list again")
+	flagMaxRPS               = flag.Float64("max-rps", 0, "Maximum number of SmartThings API requests per second (0 = unlimited)")
+	flagLocation             = flag.String("location", "", "Only collect devices in this location (ID or name, v1 API)")
+	flagLogLevel             = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	flagLogFormat            = flag.String("log-format", "text", "Log format: text or json")
+	flagBatteryLow           = flag.Float64("battery-low", 20, "Battery level (percent) below which smartthings_battery_low is set")
+	flagCustomCapabilities   = flag.Bool("custom-capabilities", false, "Export numeric and enum attributes of custom capabilities (v1 API)")
+	flagTemperatureUnit      = flag.String("temperature-unit", collector.TemperatureFahrenheit, "Convert temperatures to this unit: celsius, fahrenheit or raw (no conversion, unit in a label)")
+	flagEnumStyle            = flag.String("enum-style", collector.EnumNumeric, "Export attributes with a fixed set of states as numeric values (numeric) or one series per state (statelabel)")
+	flagDeviceHealth         = flag.Bool("device-health", false, "Export the device health state reported by SmartThings (v1 API, one more request per device)")
+	flagLocationMode         = flag.Bool("location-mode", false, "Export the current mode of each location (v1 API, two more requests per location)")
+	flagInventory            = flag.Bool("inventory", false, "Export the scenes, rules and installed SmartApps of each location (v1 API, three or more requests per location)")
+	flagMetricPrefix         = flag.String("metric-prefix", collector.DefaultPrefix, "Prefix for the names of device metrics")
+	flagSampleTimestamps     = flag.Bool("sample-timestamps", false, "Timestamp samples on /metrics with the time SmartThings last updated the attribute (serve command, v1 API)")
+	flagWebhook              = flag.Bool("webhook", false, "Receive device events as a SmartThings webhook SmartApp on /smartapp (serve command)")
 )
 
-func main() {
-	flag.Parse()
+var (
+	// flagLabels holds the static labels added to all metrics (--label).
+	flagLabels labelFlag
+
+	// flagVictoriaMetricsLabels holds the extra labels added by
+	// VictoriaMetrics on import (--victoriametrics-label).
+	flagVictoriaMetricsLabels labelFlag
+)
+
+func init() {
+	flag.Var(&flagLabels, "label", "Add a label to all metrics, as name=value (repeatable)")
+	flag.Var(&flagVictoriaMetricsLabels, "victoriametrics-label", "Extra label added by VictoriaMetrics to all imported metrics, as name=value (repeatable)")
+	// --format is an alias for --output.
+	flag.Var(flag.Lookup("output").Value, "format", "Same as --output")
+}
+
+// validPrefix matches valid metric name prefixes (including an empty one.)
+var validPrefix = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$|^$`)
 
-	// No date on log messages
-	log.SetFlags(0)
+// commands holds the description of all valid subcommands.
+var commands = map[string]string{
+	"collect": "Collect sensor data and save it to the textfile collector directory (default)",
+	"serve":   "Serve sensor data via HTTP on a /metrics endpoint, collecting on every scrape",
+	"auth":    "Authorize smartcollector with SmartThings and save the token",
+	"devices": "List all devices and their IDs",
+}
 
-	if *flagClient == "" {
-		log.Fatalf("Must specify Client ID (--client)")
+// usage prints the list of commands and flags to stderr.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name])
 	}
-	tfile := tokenFilePrefix + "_" + *flagClient + ".json"
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	// Create the oauth2.config object and get a token
-	config := gosmart.NewOAuthConfig(*flagClient, *flagSecret)
-	token, err := gosmart.GetToken(tfile, config)
-	if err != nil {
-		log.Fatalf("Error fetching token: %v", err)
+func main() {
+	// The command is the first argument, unless it looks like a flag. We
+	// default to collect to keep old command lines working.
+	cmd, args := "collect", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	flag.Usage = usage
+	if _, ok := commands[cmd]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n\n", strings.Join(flag.Args(), " "))
+		usage()
+		os.Exit(2)
 	}
 
-	// Create a client with the token and fetch endpoints URI.
-	ctx := context.Background()
-	client := config.Client(ctx, token)
-	endpoint, err := gosmart.GetEndPointsURI(client, gosmart.EndPointsURI)
-	if err != nil {
-		log.Fatalf("Error reading endpoints URI: %v\n", err)
+	// Environment variables are used for flags not set in the command line.
+	if err := mergeEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading environment: %v\n", err)
+		os.Exit(2)
+	}
+	switch *flagOutput {
+	case "text", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid output format %q (valid formats are text, json and csv)\n", *flagOutput)
+		os.Exit(2)
+	}
+	switch *flagTemperatureUnit {
+	case collector.TemperatureCelsius, collector.TemperatureFahrenheit, collector.TemperatureRaw:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid temperature unit %q (valid units are celsius, fahrenheit and raw)\n", *flagTemperatureUnit)
+		os.Exit(2)
+	}
+	if *flagEnumStyle != collector.EnumNumeric && *flagEnumStyle != collector.EnumStateLabel {
+		fmt.Fprintf(os.Stderr, "Invalid enum style %q (valid styles are numeric and statelabel)\n", *flagEnumStyle)
+		os.Exit(2)
+	}
+	if *flagOTLPProtocol != "grpc" && *flagOTLPProtocol != "http" {
+		fmt.Fprintf(os.Stderr, "Invalid OTLP protocol %q (valid protocols are grpc and http)\n", *flagOTLPProtocol)
+		os.Exit(2)
+	}
+	switch *flagEvents {
+	case "", "syslog", "journal":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid event destination %q (valid destinations are syslog and journal)\n", *flagEvents)
+		os.Exit(2)
+	}
+	if *flagTimeout <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid timeout %s: must be positive\n", *flagTimeout)
+		os.Exit(2)
+	}
+	if *flagRetries < 0 || *flagRetryDelay < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid retries (%d) or retry delay (%s): must not be negative\n", *flagRetries, *flagRetryDelay)
+		os.Exit(2)
+	}
+	if !validPrefix.MatchString(*flagMetricPrefix) {
+		fmt.Fprintf(os.Stderr, "Invalid metric prefix %q\n", *flagMetricPrefix)
+		os.Exit(2)
+	}
+	if err := setupLogging(*flagLogLevel, *flagLogFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
 	}
 
-	// Iterate over all devices and collect timeseries info.
-	devs, err := gosmart.GetDevices(client, endpoint)
-	if err != nil {
-		log.Fatalf("Error reading list of devices: %v\n", err)
+	// Values from the configuration file are used unless explicitly
+	// overridden on the command line or environment.
+	cfg := &config{}
+	if *flagConfig != "" {
+		var err error
+		if cfg, err = loadConfig(*flagConfig); err != nil {
+			fatal("Error loading config", "error", err)
+		}
+		mergeConfig(cfg)
 	}
 
-	ts := []string{}
+	ctx := context.Background()
 
-	for _, dev := range devs {
-		devinfo, err := gosmart.GetDeviceInfo(client, endpoint, dev.ID)
-		if err != nil {
-			log.Fatalf("Error reading device info: %v\n", err)
-		}
-		t, err := getTimeSeries(devinfo)
+	// Accounts come from the configuration file or, if none is configured
+	// there, from the command-line flags.
+	accounts := cfg.Accounts
+	if len(accounts) == 0 {
+		accounts = []account{{
+			API:    *flagAPI,
+			Client: *flagClient,
+			Secret: *flagSecret,
+			Token:  *flagToken,
+		}}
+	}
+
+	srcs := map[string]collector.Source{}
+	for _, acct := range accounts {
+		src, err := newSource(ctx, acct, cmd == "auth")
 		if err != nil {
-			log.Fatalf("Error processing sensor data: %v\n", err)
+			fatal("Error initializing account", "account", acct.Name, "error", err)
 		}
-		ts = append(ts, t...)
+		srcs[acct.Name] = src
+	}
+	if cmd == "auth" {
+		return
 	}
 
-	// Save timeseries (or just print if dry-run active)
-	if *flagDryRun {
-		for _, v := range ts {
-			fmt.Println(v)
+	// Devices from multiple accounts are tagged with the account name.
+	var src collector.Source
+	if len(srcs) == 1 {
+		for _, s := range srcs {
+			src = s
 		}
 	} else {
-		f := filepath.Join(*flagTextFileCollectorDir, textFileCollectorName)
-		if err := saveTimeSeries(f, ts); err != nil {
-			log.Fatalf("Error saving timeseries: %v\n", err)
+		src = collector.NewMultiSource(srcs)
+	}
+
+	// Button presses are counted across runs.
+	buttons := loadButtons(buttonStateFile)
+	col := newCollector(src, cfg)
+	col.Buttons = buttons
+
+	// Long-running modes exit cleanly on SIGINT/SIGTERM after finishing any
+	// collection in progress.
+	stop := make(chan os.Signal, 1)
+	if cmd == "serve" || *flagInterval > 0 {
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	}
+
+	// SIGHUP reloads the configuration file in long-running modes.
+	hup := make(chan os.Signal, 1)
+	if (cmd == "serve" || *flagInterval > 0) && *flagConfig != "" {
+		signal.Notify(hup, syscall.SIGHUP)
+	}
+
+	switch cmd {
+	case "serve":
+		// In webhook mode, metrics come from a cache populated once at startup
+		// and updated by the device events sent by SmartThings.
+		if *flagWebhook {
+			cache := collector.NewCache(src)
+			cache.MaxConcurrency = *flagMaxConcurrency
+			if err := cache.Refresh(ctx); err != nil {
+				if *flagStrict {
+					fatal("Error reading initial device state", "error", err)
+				}
+				slog.Error("Error reading initial device state", "error", err)
+			}
+			http.Handle(webhookPath, &smartApp{
+				cache:    cache,
+				verifier: newSignatureVerifier(smartThingsKeyURL, *flagTimeout),
+				timeout:  *flagTimeout,
+			})
+
+			// Periodically poll all devices to reconcile any missed events.
+			if *flagInterval > 0 {
+				go func() {
+					for {
+						time.Sleep(*flagInterval)
+						done := startCollection()
+						err := cache.Refresh(ctx)
+						done()
+						if err != nil {
+							slog.Error("Error refreshing device state", "error", err)
+						}
+					}
+				}()
+			}
+			col = newCollector(cache, cfg)
+			col.Buttons = buttons
+		}
+		go func() {
+			for range hup {
+				if _, err := reloadConfig(col); err != nil {
+					slog.Error("Error reloading config, keeping the current one", "error", err)
+				}
+			}
+		}()
+		aux, err := sink.Open(auxiliarySinks...)
+		if err != nil {
+			fatal("Error opening outputs", "error", err)
+		}
+		defer closeSinks(aux)
+		if err := serveMetrics(*flagListen, col, aux, stop); err != nil {
+			fatal("Error serving metrics", "error", err)
+		}
+	case "devices":
+		if err := listDevices(ctx, col); err != nil {
+			fatal("Error listing devices", "error", err)
+		}
+	case "collect":
+		// Outputs are opened once, and reused for all runs.
+		var sinks map[string]sink.Sink
+		if !*flagDryRun {
+			var err error
+			if sinks, err = openSinks(); err != nil {
+				fatal("Error opening outputs", "error", err)
+			}
+			defer closeSinks(sinks)
+		}
+		if *flagInterval == 0 {
+			if err := run(ctx, col, sinks); err != nil {
+				fatal("Error collecting metrics", "error", err)
+			}
+			return
+		}
+		// Daemon mode: errors are logged and the next run proceeds as usual.
+		// Systemd is notified after the first successful run, and the
+		// watchdog (if enabled) is notified while we wait for the next run.
+		// A run that hangs for longer than WatchdogSec gets us restarted.
+		var watchdog <-chan time.Time
+		if wd := sdWatchdogInterval(); wd > 0 {
+			watchdog = time.NewTicker(wd).C
+		}
+		for {
+			if err := run(ctx, col, sinks); err != nil {
+				slog.Error("Error collecting metrics", "error", err)
+			} else {
+				sdReady()
+			}
+			sdNotify("WATCHDOG=1")
+
+			next := time.After(*flagInterval)
+		wait:
+			for {
+				select {
+				case sig := <-stop:
+					slog.Info("Exiting", "signal", sig.String())
+					sdNotify("STOPPING=1")
+					return
+				case <-hup:
+					newcfg, err := reloadConfig(col)
+					if err != nil {
+						slog.Error("Error reloading config, keeping the current one", "error", err)
+						continue
+					}
+					if !setFlags()["interval"] && newcfg.interval != 0 && newcfg.interval != *flagInterval {
+						*flagInterval = newcfg.interval
+						next = time.After(*flagInterval)
+					}
+				case <-watchdog:
+					sdNotify("WATCHDOG=1")
+				case <-next:
+					break wait
+				}
+			}
 		}
 	}
 }
 
-// saveTimeSeries saves the array of strings to a temporary file and renames
-// the resulting file into a node exporter textfile collector file.
-func saveTimeSeries(fname string, ts []string) error {
-	// Silly temp name. Uniqueness should be sufficient (famous last words...)
-	tempfile := fmt.Sprintf("%s-%d-%d", fname, os.Getpid(), os.Getppid())
+// newCollector returns a new collector for src using the device filters and
+// attribute mappings in the configuration.
+func newCollector(src collector.Source, cfg *config) *collector.Collector {
+	col := collector.New(src)
+	col.Prefix = *flagMetricPrefix
+	col.TemperatureUnit = *flagTemperatureUnit
+	col.EnumStyle = *flagEnumStyle
+	col.Filter = filterFunc(cfg, *flagLocation)
+	col.Converters = cfg.mappings
+	col.BatteryLow = batteryLowFunc(cfg, *flagBatteryLow)
+	col.Relabel = relabelFunc(cfg)
+	col.Labels = flagLabels
+	col.MaxConcurrency = *flagMaxConcurrency
+	col.Strict = *flagStrict
+	col.APIErrors = apiErrors
+	col.OnError = func(dev collector.Device, err error) {
+		slog.Warn("Skipping device", "id", dev.ID, "name", dev.Name, "error", err)
+	}
+	col.OnAccountError = func(account string, err error) {
+		slog.Warn("Skipping account", "account", account, "error", err)
+	}
+	return col
+}
 
-	// Create file and write every ts line into it, adding newline.
-	w, err := os.Create(tempfile)
-	if err != nil {
-		return err
+// filterFunc returns a device filter selecting devices in location (if not
+// empty) and matching the device filters in the configuration.
+func filterFunc(cfg *config, location string) func(collector.Device) bool {
+	return func(dev collector.Device) bool {
+		if location != "" && dev.LocationID != location && dev.Location != location {
+			return false
+		}
+		return cfg.wantDevice(dev)
 	}
-	defer w.Close()
-	for _, v := range ts {
-		w.Write([]byte(v + "\n"))
+}
+
+// batteryLowFunc returns a function returning the low battery threshold for
+// a device, according to the configuration, or threshold if none is set.
+func batteryLowFunc(cfg *config, threshold float64) func(collector.Device, map[string]interface{}) float64 {
+	return func(dev collector.Device, attrs map[string]interface{}) float64 {
+		return cfg.batteryLow(dev, attrs, threshold)
 	}
-	w.Close()
+}
 
-	// Rename to real name
-	err = os.Rename(tempfile, fname)
+// reloadConfig reads the configuration file again and applies the new device
+// filters, attribute mappings, battery thresholds, device labels and relabeling
+// rules to col, and the new notification rules. The new configuration is returned so the caller can apply
+// other settings. Credentials and output settings are not reloaded.
+func reloadConfig(col *collector.Collector) (*config, error) {
+	cfg, err := loadConfig(*flagConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	location := *flagLocation
+	if !setFlags()["location"] && cfg.Location != "" {
+		location = cfg.Location
+	}
+	threshold := *flagBatteryLow
+	if !setFlags()["battery-low"] && cfg.BatteryLow.Threshold != 0 {
+		threshold = cfg.BatteryLow.Threshold
+	}
+	col.Reconfigure(filterFunc(cfg, location), cfg.mappings, batteryLowFunc(cfg, threshold), relabelFunc(cfg))
+	setNotifyRules(cfg.Notify)
+	slog.Info("Configuration reloaded", "file", *flagConfig)
+	return cfg, nil
 }
 
-// getTimeSeries returns a prometheus compatible timeseries from the device data.
-func getTimeSeries(devinfo *gosmart.DeviceInfo) ([]string, error) {
-	var err error
-	var value float64
+// mergeConfig sets the value of all flags not explicitly set in the command
+// line (or environment) from the equivalent value in the configuration file,
+// when present.
+func mergeConfig(cfg *config) {
+	set := setFlags()
 
-	valOpenClosed := []string{"open", "closed"}
-	valInactiveActive := []string{"inactive", "active"}
-	valAbsentPresent := []string{"not present", "present"}
-	valOffOn := []string{"off", "on"}
+	if !set["client"] && cfg.Client != "" {
+		*flagClient = cfg.Client
+	}
+	if !set["secret"] && cfg.Secret != "" {
+		*flagSecret = cfg.Secret
+	}
+	if !set["textfile-dir"] && cfg.TextFileDir != "" {
+		*flagTextFileCollectorDir = cfg.TextFileDir
+	}
+	if !set["location"] && cfg.Location != "" {
+		*flagLocation = cfg.Location
+	}
+	if !set["api"] && cfg.API != "" {
+		*flagAPI = cfg.API
+	}
+	if !set["token"] && cfg.Token != "" {
+		*flagToken = cfg.Token
+	}
+	if !set["interval"] && cfg.interval != 0 {
+		*flagInterval = cfg.interval
+	}
+	if !set["notify-url"] && cfg.NotifyURL != "" {
+		*flagNotifyURL = cfg.NotifyURL
+	}
+	setNotifyRules(cfg.Notify)
+	if !set["battery-low"] && cfg.BatteryLow.Threshold != 0 {
+		*flagBatteryLow = cfg.BatteryLow.Threshold
+	}
+}
 
-	ret := []string{}
+// run collects the timeseries for all devices once and saves them to sinks
+// (or just prints them if dry-run is active.)
+func run(ctx context.Context, col *collector.Collector, sinks map[string]sink.Sink) error {
+	ts, err := col.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Collection finished", "metrics", len(ts))
+	saveButtons(buttonStateFile, col.Buttons)
 
-	for k, val := range devinfo.Attributes {
-		// Some sensors report nil as a value (instead of a blank string) so we
-		// convert nil to an empty string to avoid issues with type assertion.
-		if val == nil {
-			val = ""
+	if *flagDryRun {
+		switch *flagOutput {
+		case "json":
+			return writeJSON(os.Stdout, ts, time.Now())
+		case "csv":
+			return writeCSV(os.Stdout, ts, time.Now())
 		}
+		return writeTimeSeries(os.Stdout, ts)
+	}
+	return writeSinks(ctx, sinks, sink.Samples(ts, time.Now()))
+}
 
-		switch k {
-		case "alarmState":
-			value, err = valueClear(val)
-		case "battery":
-			value, err = valueFloat(val)
-		case "carbonMonoxide":
-			value, err = valueClear(val)
-		case "contact":
-			value, err = valueOneOf(val, valOpenClosed)
-		case "energy":
-			value, err = valueFloat(val)
-		case "motion":
-			value, err = valueOneOf(val, valInactiveActive)
-		case "power":
-			value, err = valueFloat(val)
-		case "presence":
-			value, err = valueOneOf(val, valAbsentPresent)
-		case "smoke":
-			value, err = valueClear(val)
-		case "switch":
-			value, err = valueOneOf(val, valOffOn)
-		case "temperature":
-			value, err = valueFloat(val)
-		default:
-			// We only process keys we know about.
-			continue
-		}
-		if err != nil {
-			return nil, err
-		}
-		ret = append(ret, fmt.Sprintf("smartthings_sensors{id=\"%s\",name=\"%s\",attr=\"%v\"} %v", devinfo.ID, devinfo.DisplayName, k, value))
+// hostname returns the name of the local host, or an empty string if it
+// cannot be determined.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
-	return ret, nil
+	return h
 }
 
-// valueClear expects a string and returns 0 for "clear", 1 for anything else.
-// TODO: Expand this to properly identify non-clear conditions and return error
-// in case an unexpected value is found.
-func valueClear(v interface{}) (float64, error) {
-	val, ok := v.(string)
-	if !ok {
-		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
+// listDevices prints the ID, display name and location of all devices to
+// stdout.
+func listDevices(ctx context.Context, col *collector.Collector) error {
+	devs, err := col.Devices(ctx)
+	if err != nil {
+		return err
 	}
-	if val != "clear" {
-		return 0.0, nil
+	for _, dev := range devs {
+		fmt.Printf("%s\t%s\t%s\n", dev.ID, dev.Name, dev.Location)
 	}
-	return 1.0, nil
+	return nil
 }
 
-// valueOneOf returns 0.0 if the value matches the first item
-// in the array, 1.0 if it matches the second, and an error if
-// nothing matches.
-func valueOneOf(v interface{}, options []string) (float64, error) {
-	val, ok := v.(string)
-	if !ok {
-		return 0.0, fmt.Errorf("invalid non-string argument %v", v)
-	}
-	if val == options[0] {
-		return 0.0, nil
+// saveTimeSeries saves the array of metrics to a temporary file and renames
+// the resulting file into a node exporter textfile collector file. The
+// temporary file is removed in case of errors.
+func saveTimeSeries(fname string, ts []collector.Metric) error {
+	// Silly temp name. Uniqueness should be sufficient (famous last words...)
+	tempfile := fmt.Sprintf("%s-%d-%d", fname, os.Getpid(), os.Getppid())
+
+	// Create file and write all metrics into it.
+	w, err := os.Create(tempfile)
+	if err != nil {
+		return err
 	}
-	if val == options[1] {
-		return 1.0, nil
+	err = writeTimeSeries(w, ts)
+	if cerr := w.Close(); err == nil {
+		err = cerr
 	}
-	return 0.0, fmt.Errorf("invalid option %q. Expected %q or %q", val, options[0], options[1])
-}
 
-// valueFloat returns the float64 value of the value passed or
-// error if the value cannot be converted. Accepts float64 and
-// strings as valid arguments.
-func valueFloat(v interface{}) (float64, error) {
-	switch val := v.(type) {
-	case string:
-		ret, err := strconv.ParseFloat(val, 64)
-		if err != nil {
-			return 0.0, fmt.Errorf("unable to convert %q to float: %v", val, v)
-		}
-		return ret, nil
-	case float64:
-		ret, ok := v.(float64)
-		if !ok {
-			return 0.0, fmt.Errorf("unable to convert \"%v\" to string", v)
-		}
-		return ret, nil
+	// Rename to real name
+	if err == nil {
+		err = os.Rename(tempfile, fname)
+	}
+	if err != nil {
+		os.Remove(tempfile)
+		return err
 	}
-	return 0.0, fmt.Errorf("invalid type for \"%v\": %T", v, v)
+	return nil
 }
@@ -0,0 +1,125 @@
+// SQLite history store for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+
+	// Pure Go SQLite driver (registered as "sqlite").
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	sink.Register("sqlite", func() (sink.Sink, error) {
+		if *flagSQLiteDB == "" {
+			return nil, nil
+		}
+		db, err := openSQLite(context.Background(), *flagSQLiteDB)
+		if err != nil {
+			return nil, err
+		}
+		return withClose(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return writeSQLite(ctx, db, ts, t)
+		}), db.Close), nil
+	})
+}
+
+// sqliteMigrations holds the statements creating and updating the history
+// database schema, in order. The number of migrations applied is kept in the
+// database user_version. Never change or remove existing migrations: append
+// new ones instead.
+var sqliteMigrations = []string{
+	// 1: One row per reading. Time is in seconds since the epoch, and labels
+	// (other than the device ID and name) are a JSON object.
+	`CREATE TABLE readings (
+		time      INTEGER NOT NULL,
+		metric    TEXT NOT NULL,
+		device_id TEXT NOT NULL DEFAULT '',
+		device    TEXT NOT NULL DEFAULT '',
+		attribute TEXT NOT NULL DEFAULT '',
+		value     REAL NOT NULL,
+		labels    TEXT NOT NULL DEFAULT '{}'
+	)`,
+	// 2: History queries are usually per device and metric over time.
+	`CREATE INDEX readings_device_metric_time ON readings (device_id, metric, time)`,
+}
+
+// openSQLite opens (creating if needed) the SQLite database in fname and
+// brings its schema up to date.
+func openSQLite(ctx context.Context, fname string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+fname+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateSQLite applies all pending migrations, each in its own transaction.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported (%d)", version, len(sqliteMigrations))
+	}
+	for n := version; n < len(sqliteMigrations); n++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[n]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", n+1, err)
+		}
+		// PRAGMA does not accept placeholders.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", n+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", n+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %v", n+1, err)
+		}
+	}
+	return nil
+}
+
+// writeSQLite records the metrics collected at time t in the SQLite database
+// db (opened with openSQLite), in a single transaction.
+func writeSQLite(ctx context.Context, db *sql.DB, ts []collector.Metric, t time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO readings (time, metric, device_id, device, attribute, value, labels) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, m := range ts {
+		rec := newJSONRecord(m, t)
+		labels, err := labelsJSON(rec)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, t.Unix(), rec.Metric, rec.ID, rec.Name, rec.Attribute, rec.Value, labels); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,243 @@
+// SQLite history store for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// fakeSQLite is a database/sql driver logging the statements executed (the
+// first two words of each, and the arguments of inserts), with the schema
+// version in user_version. Inserts fail for the metric in failMetric, and
+// CREATE statements with failMigrate.
+type fakeSQLite struct {
+	mu           sync.Mutex
+	log          []string
+	userVersion  int64
+	failMetric   string
+	failMigrate  bool
+	insertedRows [][]driver.Value
+}
+
+func (d *fakeSQLite) record(s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, s)
+}
+
+func (d *fakeSQLite) Open(name string) (driver.Conn, error) { return fakeSQLiteConn{d}, nil }
+
+type fakeSQLiteConn struct{ d *fakeSQLite }
+
+func (c fakeSQLiteConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeSQLiteStmt{c.d, query}, nil
+}
+func (c fakeSQLiteConn) Close() error { return nil }
+func (c fakeSQLiteConn) Begin() (driver.Tx, error) {
+	c.d.record("BEGIN")
+	return fakeSQLiteTx{c.d}, nil
+}
+
+type fakeSQLiteTx struct{ d *fakeSQLite }
+
+func (tx fakeSQLiteTx) Commit() error   { tx.d.record("COMMIT"); return nil }
+func (tx fakeSQLiteTx) Rollback() error { tx.d.record("ROLLBACK"); return nil }
+
+type fakeSQLiteStmt struct {
+	d     *fakeSQLite
+	query string
+}
+
+func (s fakeSQLiteStmt) Close() error  { return nil }
+func (s fakeSQLiteStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s fakeSQLiteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fields := strings.Fields(s.query)
+	s.d.record(fields[0] + " " + fields[1])
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	switch {
+	case fields[0] == "INSERT":
+		if args[1] == s.d.failMetric {
+			return nil, errors.New("disk full")
+		}
+		s.d.insertedRows = append(s.d.insertedRows, args)
+	case fields[0] == "CREATE" && s.d.failMigrate:
+		return nil, errors.New("syntax error")
+	case fields[0] == "PRAGMA":
+		fmt.Sscanf(s.query, "PRAGMA user_version = %d", &s.d.userVersion)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeSQLiteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.record(s.query)
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	return &fakeSQLiteRows{value: s.d.userVersion}, nil
+}
+
+// fakeSQLiteRows returns a single integer.
+type fakeSQLiteRows struct {
+	value int64
+	done  bool
+}
+
+func (r *fakeSQLiteRows) Columns() []string { return []string{"user_version"} }
+func (r *fakeSQLiteRows) Close() error      { return nil }
+func (r *fakeSQLiteRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+var fakeSQLiteDriver = &fakeSQLite{}
+
+func init() {
+	sql.Register("fakesqlite", fakeSQLiteDriver)
+}
+
+// resetFakeSQLite clears the fake SQLite state and log.
+func resetFakeSQLite(version int64, failMetric string, failMigrate bool) {
+	fakeSQLiteDriver.mu.Lock()
+	defer fakeSQLiteDriver.mu.Unlock()
+	fakeSQLiteDriver.log = nil
+	fakeSQLiteDriver.insertedRows = nil
+	fakeSQLiteDriver.userVersion = version
+	fakeSQLiteDriver.failMetric = failMetric
+	fakeSQLiteDriver.failMigrate = failMigrate
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db, err := sql.Open("fakesqlite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	casetests := []struct {
+		name        string
+		version     int64
+		failMigrate bool
+		want        []string
+		wantVersion int64
+		wantErr     bool
+	}{
+		{
+			name: "new database",
+			want: []string{
+				"PRAGMA user_version",
+				"BEGIN", "CREATE TABLE", "PRAGMA user_version", "COMMIT",
+				"BEGIN", "CREATE INDEX", "PRAGMA user_version", "COMMIT",
+			},
+			wantVersion: 2,
+		},
+		{
+			name:        "one pending migration",
+			version:     1,
+			want:        []string{"PRAGMA user_version", "BEGIN", "CREATE INDEX", "PRAGMA user_version", "COMMIT"},
+			wantVersion: 2,
+		},
+		{
+			name:        "up to date",
+			version:     2,
+			want:        []string{"PRAGMA user_version"},
+			wantVersion: 2,
+		},
+		{
+			name:        "newer schema",
+			version:     3,
+			want:        []string{"PRAGMA user_version"},
+			wantVersion: 3,
+			wantErr:     true,
+		},
+		{
+			name:        "failed migration",
+			failMigrate: true,
+			want:        []string{"PRAGMA user_version", "BEGIN", "CREATE TABLE", "ROLLBACK"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range casetests {
+		resetFakeSQLite(tt.version, "", tt.failMigrate)
+		err := migrateSQLite(context.Background(), db)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: migrateSQLite error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		fakeSQLiteDriver.mu.Lock()
+		if !reflect.DeepEqual(fakeSQLiteDriver.log, tt.want) {
+			t.Errorf("%s: statements = %q, want %q", tt.name, fakeSQLiteDriver.log, tt.want)
+		}
+		if fakeSQLiteDriver.userVersion != tt.wantVersion {
+			t.Errorf("%s: user_version = %d, want %d", tt.name, fakeSQLiteDriver.userVersion, tt.wantVersion)
+		}
+		fakeSQLiteDriver.mu.Unlock()
+	}
+}
+
+func TestWriteSQLite(t *testing.T) {
+	db, err := sql.Open("fakesqlite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Unix(1462096800, 0)
+	ts := []collector.Metric{
+		{
+			Name:      "smartthings_power_watts",
+			Labels:    []collector.Label{{Name: "id", Value: "p1"}, {Name: "name", Value: "Plug"}, {Name: "room", Value: "Office"}},
+			Attribute: "power",
+			Value:     12.5,
+		},
+		{Name: "smartcollector_devices_total", Value: 1},
+	}
+
+	// All readings go in one transaction.
+	resetFakeSQLite(2, "", false)
+	if err := writeSQLite(context.Background(), db, ts, now); err != nil {
+		t.Fatalf("writeSQLite failed: %v", err)
+	}
+	fakeSQLiteDriver.mu.Lock()
+	if want := []string{"BEGIN", "INSERT INTO", "INSERT INTO", "COMMIT"}; !reflect.DeepEqual(fakeSQLiteDriver.log, want) {
+		t.Errorf("statements = %q, want %q", fakeSQLiteDriver.log, want)
+	}
+	wantRows := [][]driver.Value{
+		{now.Unix(), "smartthings_power_watts", "p1", "Plug", "power", 12.5, `{"room":"Office"}`},
+		{now.Unix(), "smartcollector_devices_total", "", "", "", 1.0, "{}"},
+	}
+	if !reflect.DeepEqual(fakeSQLiteDriver.insertedRows, wantRows) {
+		t.Errorf("rows = %v, want %v", fakeSQLiteDriver.insertedRows, wantRows)
+	}
+	fakeSQLiteDriver.mu.Unlock()
+
+	// A failed insert rolls back the whole collection.
+	resetFakeSQLite(2, "smartcollector_devices_total", false)
+	if err := writeSQLite(context.Background(), db, ts, now); err == nil {
+		t.Errorf("writeSQLite ignored a failed insert")
+	}
+	fakeSQLiteDriver.mu.Lock()
+	if want := []string{"BEGIN", "INSERT INTO", "INSERT INTO", "ROLLBACK"}; !reflect.DeepEqual(fakeSQLiteDriver.log, want) {
+		t.Errorf("statements = %q, want %q", fakeSQLiteDriver.log, want)
+	}
+	fakeSQLiteDriver.mu.Unlock()
+}
@@ -0,0 +1,87 @@
+// StatsD support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("statsd", func() (sink.Sink, error) {
+		if *flagStatsDAddr == "" {
+			return nil, nil
+		}
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return sendStatsD(ctx, *flagStatsDAddr, ts)
+		})), nil
+	})
+}
+
+// Maximum size of a StatsD UDP packet, small enough to avoid fragmentation
+// on common networks.
+const statsdMaxPacket = 1432
+
+// statsdEscaper replaces characters with special meaning in StatsD lines.
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+
+// statsdLine returns a metric as a StatsD gauge, with non-empty labels as
+// DogStatsD tags (e.g. "smartthings_contact_open:1|g|#name:Front door").
+func statsdLine(m collector.Metric) string {
+	var b strings.Builder
+	b.WriteString(statsdEscaper.Replace(m.Name))
+	b.WriteString(":")
+	b.WriteString(strconv.FormatFloat(m.Value, 'g', -1, 64))
+	b.WriteString("|g")
+	sep := "|#"
+	for _, l := range m.Labels {
+		if l.Value == "" {
+			continue
+		}
+		b.WriteString(sep + statsdEscaper.Replace(l.Name) + ":" + statsdEscaper.Replace(l.Value))
+		sep = ","
+	}
+	return b.String()
+}
+
+// sendStatsD sends all metrics as gauges to a StatsD (or DogStatsD) server at
+// addr (host:port) over UDP, packing as many lines as possible in each
+// packet.
+func sendStatsD(ctx context.Context, addr string, ts []collector.Metric) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	packet := []byte{}
+	for _, m := range ts {
+		line := statsdLine(m)
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
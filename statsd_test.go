@@ -0,0 +1,98 @@
+// StatsD support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestStatsDLine(t *testing.T) {
+	casetests := []struct {
+		name   string
+		metric collector.Metric
+		want   string
+	}{
+		{
+			name: "tags",
+			metric: collector.Metric{
+				Name:   "smartthings_contact_open",
+				Labels: []collector.Label{{Name: "name", Value: "Front door"}, {Name: "hub", Value: ""}, {Name: "room", Value: "Hall"}},
+				Value:  1,
+			},
+			want: "smartthings_contact_open:1|g|#name:Front door,room:Hall",
+		},
+		{
+			name: "escaping",
+			metric: collector.Metric{
+				Name:   "a:b",
+				Labels: []collector.Label{{Name: "name", Value: "x|y,z#1@2"}},
+				Value:  -2.5,
+			},
+			want: "a_b:-2.5|g|#name:x_y_z_1_2",
+		},
+		{
+			name:   "no labels",
+			metric: collector.Metric{Name: "smartcollector_devices_total", Value: 3},
+			want:   "smartcollector_devices_total:3|g",
+		},
+	}
+
+	for _, tt := range casetests {
+		if got := statsdLine(tt.metric); got != tt.want {
+			t.Errorf("%s: statsdLine = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	// Enough metrics to need several packets.
+	ts := []collector.Metric{}
+	want := []string{}
+	for n := 0; n < 100; n++ {
+		m := collector.Metric{Name: fmt.Sprintf("smartthings_metric_%03d", n), Labels: []collector.Label{{Name: "name", Value: "Device"}}, Value: float64(n)}
+		ts = append(ts, m)
+		want = append(want, statsdLine(m))
+	}
+	if err := sendStatsD(context.Background(), pc.LocalAddr().String(), ts); err != nil {
+		t.Fatalf("sendStatsD failed: %v", err)
+	}
+
+	got := []string{}
+	packets := 0
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < len(want) {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("error reading packet %d: %v", packets+1, err)
+		}
+		if n > statsdMaxPacket {
+			t.Errorf("packet %d has %d bytes, more than %d", packets+1, n, statsdMaxPacket)
+		}
+		packets++
+		got = append(got, strings.Split(string(buf[:n]), "\n")...)
+	}
+	if packets < 2 {
+		t.Errorf("got %d packets, want several", packets)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received lines %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+// systemd integration for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// readyOnce makes sure we notify systemd only once.
+var readyOnce sync.Once
+
+// sdNotify sends a state notification (e.g. "READY=1") to systemd. This is
+// a no-op when not running under systemd with Type=notify (NOTIFY_SOCKET
+// unset).
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	// A leading @ indicates an abstract socket.
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Error connecting to systemd notify socket", "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Error notifying systemd", "state", state, "error", err)
+	}
+}
+
+// sdReady tells systemd that the service is ready. Only the first call has
+// any effect.
+func sdReady() {
+	readyOnce.Do(func() {
+		sdNotify("READY=1")
+	})
+}
+
+// sdWatchdogInterval returns the interval at which the systemd watchdog
+// should be notified (half of the configured WatchdogSec), or zero if the
+// watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
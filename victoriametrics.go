@@ -0,0 +1,70 @@
+// VictoriaMetrics support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	sink.Register("victoriametrics", func() (sink.Sink, error) {
+		if *flagVictoriaMetricsURL == "" {
+			return nil, nil
+		}
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return importVictoriaMetrics(ctx, *flagVictoriaMetricsURL, flagVictoriaMetricsLabels, ts)
+		})), nil
+	})
+}
+
+// importVictoriaMetrics sends the array of timeseries in the Prometheus text
+// format to the /api/v1/import/prometheus endpoint of a VictoriaMetrics
+// server at baseURL (or vminsert, including any /insert/<tenant>/prometheus
+// path prefix). Extra labels are added to all series by VictoriaMetrics.
+func importVictoriaMetrics(ctx context.Context, baseURL string, extraLabels []collector.Label, ts []collector.Metric) error {
+	q := url.Values{}
+	for _, l := range extraLabels {
+		q.Add("extra_label", l.Name+"="+l.Value)
+	}
+	u := strings.TrimRight(baseURL, "/") + "/api/v1/import/prometheus"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	body := &bytes.Buffer{}
+	if err := writeTimeSeries(body, ts); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// VictoriaMetrics returns 204 on success.
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from victoriametrics %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// VictoriaMetrics support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestImportVictoriaMetrics(t *testing.T) {
+	ts := []collector.Metric{
+		{Name: "smartthings_switch_on", Labels: []collector.Label{{Name: "name", Value: "Lamp"}}, Value: 1, Help: "Whether the switch is on."},
+	}
+	want := &bytes.Buffer{}
+	if err := writeTimeSeries(want, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	casetests := []struct {
+		name       string
+		path       string
+		labels     []collector.Label
+		status     int
+		wantPath   string
+		wantLabels []string
+		wantErr    bool
+	}{
+		{
+			name:     "single node",
+			path:     "/",
+			status:   http.StatusNoContent,
+			wantPath: "/api/v1/import/prometheus",
+		},
+		{
+			name:       "cluster with extra labels",
+			path:       "/insert/0/prometheus",
+			labels:     []collector.Label{{Name: "job", Value: "smartcollector"}, {Name: "site", Value: "home"}},
+			status:     http.StatusNoContent,
+			wantPath:   "/insert/0/prometheus/api/v1/import/prometheus",
+			wantLabels: []string{"job=smartcollector", "site=home"},
+		},
+		{
+			name:     "server error",
+			path:     "",
+			status:   http.StatusBadRequest,
+			wantPath: "/api/v1/import/prometheus",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range casetests {
+		var (
+			gotPath   string
+			gotLabels []string
+			gotBody   []byte
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotLabels = r.URL.Query()["extra_label"]
+			gotBody, _ = io.ReadAll(r.Body)
+			w.WriteHeader(tt.status)
+		}))
+
+		err := importVictoriaMetrics(context.Background(), server.URL+tt.path, tt.labels, ts)
+		server.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: importVictoriaMetrics error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if gotPath != tt.wantPath {
+			t.Errorf("%s: path = %q, want %q", tt.name, gotPath, tt.wantPath)
+		}
+		if !reflect.DeepEqual(gotLabels, tt.wantLabels) {
+			t.Errorf("%s: extra labels = %q, want %q", tt.name, gotLabels, tt.wantLabels)
+		}
+		if !bytes.Equal(gotBody, want.Bytes()) {
+			t.Errorf("%s: body = %q, want %q", tt.name, gotBody, want.Bytes())
+		}
+	}
+}
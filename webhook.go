@@ -0,0 +1,294 @@
+// SmartThings webhook SmartApp support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/smartthings"
+	"golang.org/x/net/context"
+)
+
+// Path where SmartThings sends lifecycle requests for the webhook SmartApp.
+const webhookPath = "/smartapp"
+
+// Maximum size of a lifecycle request body.
+const maxWebhookBody = 1 << 20
+
+// smartAppRequest holds the fields we use from SmartApp lifecycle requests.
+type smartAppRequest struct {
+	Lifecycle string `json:"lifecycle"`
+	PingData  struct {
+		Challenge string `json:"challenge"`
+	} `json:"pingData"`
+	ConfirmationData struct {
+		AppID           string `json:"appId"`
+		ConfirmationURL string `json:"confirmationUrl"`
+	} `json:"confirmationData"`
+	ConfigurationData struct {
+		Phase  string `json:"phase"`
+		PageID string `json:"pageId"`
+	} `json:"configurationData"`
+	InstallData installData `json:"installData"`
+	UpdateData  installData `json:"updateData"`
+	EventData   struct {
+		Events []smartAppEvent `json:"events"`
+	} `json:"eventData"`
+}
+
+// installData holds the data sent with INSTALL and UPDATE lifecycle requests.
+type installData struct {
+	AuthToken    string `json:"authToken"`
+	InstalledApp struct {
+		InstalledAppID string `json:"installedAppId"`
+		LocationID     string `json:"locationId"`
+	} `json:"installedApp"`
+}
+
+// smartAppEvent holds a single event delivered to the SmartApp.
+type smartAppEvent struct {
+	EventType   string `json:"eventType"`
+	DeviceEvent struct {
+		DeviceID    string      `json:"deviceId"`
+		ComponentID string      `json:"componentId"`
+		Capability  string      `json:"capability"`
+		Attribute   string      `json:"attribute"`
+		Value       interface{} `json:"value"`
+		Unit        string      `json:"unit"`
+	} `json:"deviceEvent"`
+}
+
+// smartApp handles SmartApp lifecycle requests, updating the cache with the
+// device events sent by SmartThings. Requests other than PING must carry a
+// valid SmartThings signature.
+type smartApp struct {
+	cache    *collector.Cache
+	verifier *signatureVerifier
+	timeout  time.Duration
+}
+
+func (s *smartApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := smartAppRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Lifecycle != "PING" {
+		if err := s.verifier.verify(r, body); err != nil {
+			slog.Warn("Rejected unsigned SmartApp request", "lifecycle", req.Lifecycle, "remote", r.RemoteAddr, "error", err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var resp interface{}
+
+	switch req.Lifecycle {
+	case "PING":
+		resp = map[string]interface{}{"pingData": req.PingData}
+	case "CONFIRMATION":
+		// SmartThings requires a GET on the confirmation URL to enable the app.
+		if err := s.confirm(req.ConfirmationData.ConfirmationURL); err != nil {
+			slog.Error("Error confirming SmartApp registration", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("SmartApp confirmed", "app", req.ConfirmationData.AppID)
+		resp = map[string]interface{}{}
+	case "CONFIGURATION":
+		resp = configurationResponse(req.ConfigurationData.Phase)
+	case "INSTALL":
+		go s.subscribe(req.InstallData)
+		resp = map[string]interface{}{"installData": map[string]interface{}{}}
+	case "UPDATE":
+		go s.subscribe(req.UpdateData)
+		resp = map[string]interface{}{"updateData": map[string]interface{}{}}
+	case "UNINSTALL":
+		resp = map[string]interface{}{"uninstallData": map[string]interface{}{}}
+	case "EVENT":
+		for _, ev := range req.EventData.Events {
+			s.handleEvent(ev)
+		}
+		resp = map[string]interface{}{"eventData": map[string]interface{}{}}
+	default:
+		http.Error(w, "unknown lifecycle "+req.Lifecycle, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// confirm completes the SmartApp registration by fetching the confirmation URL.
+// Only HTTPS URLs on SmartThings hosts are accepted.
+func (s *smartApp) confirm(u string) error {
+	if !smartThingsURL(u) {
+		return fmt.Errorf("refusing confirmation URL %q outside of SmartThings", u)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// smartThingsURL returns true if u is an HTTPS URL on a SmartThings host.
+func smartThingsURL(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil || pu.Scheme != "https" || pu.User != nil {
+		return false
+	}
+	host := pu.Hostname()
+	return host == "smartthings.com" || strings.HasSuffix(host, ".smartthings.com")
+}
+
+// subscribe (re)creates event subscriptions for all capabilities supported by
+// the devices in the location of the installed app. This runs in the
+// background since SmartThings expects a quick response to lifecycle requests.
+func (s *smartApp) subscribe(data installData) {
+	ctx := context.Background()
+	appID := data.InstalledApp.InstalledAppID
+	client := smartthings.NewClient(data.AuthToken)
+	client.Timeout = s.timeout
+
+	if err := client.DeleteSubscriptions(ctx, appID); err != nil {
+		slog.Error("Error deleting subscriptions", "app", appID, "error", err)
+		return
+	}
+
+	devs, err := client.Devices(ctx)
+	if err != nil {
+		slog.Error("Error reading list of devices", "app", appID, "error", err)
+		return
+	}
+	caps := map[string]bool{}
+	for _, dev := range devs {
+		for _, comp := range dev.Components {
+			for _, c := range comp.Capabilities {
+				caps[c.ID] = true
+			}
+		}
+	}
+
+	for c := range caps {
+		sub := smartthings.Subscription{
+			SourceType: "CAPABILITY",
+			Capability: &smartthings.CapabilitySubscription{
+				LocationID:       data.InstalledApp.LocationID,
+				Capability:       c,
+				Attribute:        "*",
+				Value:            "*",
+				StateChangeOnly:  true,
+				SubscriptionName: subscriptionName(c),
+			},
+		}
+		if err := client.CreateSubscription(ctx, appID, sub); err != nil {
+			slog.Error("Error subscribing to capability", "capability", c, "error", err)
+		}
+	}
+	slog.Info("Subscribed to device events", "app", appID, "capabilities", len(caps))
+}
+
+// subscriptionName returns a unique subscription name for a capability.
+// SmartThings limits names to 36 characters.
+func subscriptionName(capability string) string {
+	name := fmt.Sprintf("sc_%s", capability)
+	if len(name) > 36 {
+		name = name[:36]
+	}
+	return name
+}
+
+// handleEvent updates the cache with the contents of a device event.
+// Attributes of custom capabilities are named as in collector.V1Source.
+// Events for devices not seen in the last refresh are dropped. Events without
+// a unit keep the unit of the cached value, which the refresh sets to the
+// temperature scale of the location for temperatures without one.
+func (s *smartApp) handleEvent(ev smartAppEvent) {
+	if ev.EventType != "DEVICE_EVENT" {
+		return
+	}
+	de := ev.DeviceEvent
+	slog.Debug("Device event", "device", de.DeviceID, "component", de.ComponentID, "attr", de.Attribute, "value", de.Value)
+	attr := de.Attribute
+	if strings.Contains(de.Capability, ".") {
+		attr = de.Capability + "." + attr
+	}
+	key := collector.AttributeKey(de.ComponentID, attr)
+	unit := de.Unit
+	if unit == "" {
+		unit = s.cache.Unit(de.DeviceID, key)
+	}
+	if !s.cache.Update(de.DeviceID, key, collector.Timestamped{Value: de.Value, Time: time.Now(), Unit: unit}) {
+		slog.Debug("Dropped event for unknown device", "device", de.DeviceID)
+	}
+}
+
+// configurationResponse returns the response to a CONFIGURATION lifecycle
+// request. The SmartApp has a single page with no settings.
+func configurationResponse(phase string) interface{} {
+	if phase == "INITIALIZE" {
+		return map[string]interface{}{
+			"configurationData": map[string]interface{}{
+				"initialize": map[string]interface{}{
+					"name":        "Smartcollector",
+					"description": "Export SmartThings sensor data to Prometheus",
+					"id":          "smartcollector",
+					"permissions": []string{"r:devices:*"},
+					"firstPageId": "1",
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"configurationData": map[string]interface{}{
+			"page": map[string]interface{}{
+				"pageId":         "1",
+				"name":           "Smartcollector",
+				"nextPageId":     nil,
+				"previousPageId": nil,
+				"complete":       true,
+				"sections": []interface{}{
+					map[string]interface{}{
+						"name": "Smartcollector",
+						"settings": []interface{}{
+							map[string]interface{}{
+								"id":          "info",
+								"type":        "PARAGRAPH",
+								"name":        "Smartcollector",
+								"description": "Device events are exported as Prometheus metrics. No configuration is required.",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
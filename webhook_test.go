@@ -0,0 +1,272 @@
+// SmartThings webhook SmartApp support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+// testSigner signs requests like SmartThings does, serving its public key
+// under /keys/test.
+type testSigner struct {
+	key    *rsa.PrivateKey
+	server *httptest.Server
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/test", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pub)
+	})
+	return &testSigner{key: key, server: httptest.NewServer(mux)}
+}
+
+// request returns a POST to the webhook path with the given body and date,
+// signed over headers and carrying the digest of digestBody.
+func (s *testSigner) request(t *testing.T, body, digestBody, headers string, date time.Time) *http.Request {
+	r := httptest.NewRequest("POST", webhookPath, strings.NewReader(body))
+	sum := sha256.Sum256([]byte(digestBody))
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+
+	hashed := sha256.Sum256([]byte(signingString(r, strings.Fields(headers))))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", `Signature keyId="/keys/test",signature="`+
+		base64.StdEncoding.EncodeToString(sig)+`",headers="`+headers+`",algorithm="rsa-sha256"`)
+	return r
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	signer := newTestSigner(t)
+	defer signer.server.Close()
+	v := newSignatureVerifier(signer.server.URL, time.Second)
+
+	const body = `{"lifecycle":"EVENT"}`
+	now := time.Now()
+	casetests := []struct {
+		name    string
+		req     *http.Request
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			req:  signer.request(t, body, body, "(request-target) digest date", now),
+		},
+		{
+			name: "small clock skew",
+			req:  signer.request(t, body, body, "(request-target) digest date", now.Add(2*time.Minute)),
+		},
+		{
+			name:    "tampered body",
+			req:     signer.request(t, `{"lifecycle":"INSTALL"}`, body, "(request-target) digest date", now),
+			wantErr: true,
+		},
+		{
+			name:    "digest not signed",
+			req:     signer.request(t, body, body, "(request-target) date", now),
+			wantErr: true,
+		},
+		{
+			name:    "date not signed",
+			req:     signer.request(t, body, body, "(request-target) digest", now),
+			wantErr: true,
+		},
+		{
+			name:    "replayed",
+			req:     signer.request(t, body, body, "(request-target) digest date", now.Add(-10*time.Minute)),
+			wantErr: true,
+		},
+		{
+			name:    "future date",
+			req:     signer.request(t, body, body, "(request-target) digest date", now.Add(10*time.Minute)),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			req:     httptest.NewRequest("POST", webhookPath, strings.NewReader(body)),
+			wantErr: true,
+		},
+	}
+	for _, tt := range casetests {
+		b, _ := io.ReadAll(tt.req.Body)
+		err := v.verify(tt.req, b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verify error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Tampering with a signed header invalidates the signature.
+	r := signer.request(t, body, body, "(request-target) digest date", now)
+	r.Header.Set("Date", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	if err := v.verify(r, []byte(body)); err == nil {
+		t.Errorf("verify accepted a request with a modified Date header")
+	}
+
+	// Key IDs cannot point outside of the key server.
+	if _, err := v.key("@evil.example.com/key"); err == nil {
+		t.Errorf("key accepted a key ID outside of the key server")
+	}
+}
+
+func TestSmartAppRejectsUnsigned(t *testing.T) {
+	signer := newTestSigner(t)
+	defer signer.server.Close()
+	app := &smartApp{verifier: newSignatureVerifier(signer.server.URL, time.Second), timeout: time.Second}
+
+	casetests := []struct {
+		body string
+		want int
+	}{
+		{`{"lifecycle":"PING","pingData":{"challenge":"abc"}}`, http.StatusOK},
+		{`{"lifecycle":"CONFIRMATION","confirmationData":{"confirmationUrl":"http://169.254.169.254/"}}`, http.StatusUnauthorized},
+		{`{"lifecycle":"EVENT","eventData":{"events":[]}}`, http.StatusUnauthorized},
+	}
+	for _, tt := range casetests {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("POST", webhookPath, bytes.NewBufferString(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("ServeHTTP(%s) status = %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
+
+func TestSmartThingsURL(t *testing.T) {
+	casetests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://api.smartthings.com/apps/x/confirm-registration?token=y", true},
+		{"https://smartthings.com/", true},
+		{"http://api.smartthings.com/", false},
+		{"https://api.smartthings.com.evil.example.com/", false},
+		{"https://evilsmartthings.com/", false},
+		{"https://user@api.smartthings.com/", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+	}
+	for _, tt := range casetests {
+		if got := smartThingsURL(tt.url); got != tt.want {
+			t.Errorf("smartThingsURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+// staticSource is a collector.Source returning a single thermometer.
+type staticSource struct{}
+
+func (staticSource) Devices(ctx context.Context) ([]collector.Device, error) {
+	return []collector.Device{{ID: "t1", Name: "Thermometer"}}, nil
+}
+
+func (staticSource) Attributes(ctx context.Context, dev collector.Device) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"temperature": collector.Timestamped{Value: 20.0, Unit: "C"},
+	}, nil
+}
+
+func TestHandleEvent(t *testing.T) {
+	casetests := []struct {
+		name   string
+		device string
+		attr   string
+		value  interface{}
+		unit   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "unit from event",
+			device: "t1",
+			attr:   "temperature",
+			value:  70.0,
+			unit:   "F",
+			want:   map[string]interface{}{"temperature": collector.Timestamped{Value: 70.0, Unit: "F"}},
+		},
+		{
+			name:   "unit from cache",
+			device: "t1",
+			attr:   "temperature",
+			value:  21.0,
+			want:   map[string]interface{}{"temperature": collector.Timestamped{Value: 21.0, Unit: "C"}},
+		},
+		{
+			name:   "new attribute",
+			device: "t1",
+			attr:   "battery",
+			value:  90.0,
+			want: map[string]interface{}{
+				"temperature": collector.Timestamped{Value: 20.0, Unit: "C"},
+				"battery":     collector.Timestamped{Value: 90.0},
+			},
+		},
+		{
+			name:   "unknown device",
+			device: "x1",
+			attr:   "temperature",
+			value:  30.0,
+			want:   map[string]interface{}{"temperature": collector.Timestamped{Value: 20.0, Unit: "C"}},
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range casetests {
+		cache := collector.NewCache(staticSource{})
+		if err := cache.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		app := &smartApp{cache: cache}
+
+		ev := smartAppEvent{EventType: "DEVICE_EVENT"}
+		ev.DeviceEvent.DeviceID = tt.device
+		ev.DeviceEvent.ComponentID = "main"
+		ev.DeviceEvent.Attribute = tt.attr
+		ev.DeviceEvent.Value = tt.value
+		ev.DeviceEvent.Unit = tt.unit
+		app.handleEvent(ev)
+
+		got, _ := cache.Attributes(ctx, collector.Device{ID: "t1"})
+		// Event times are not deterministic.
+		for k, v := range got {
+			if ts, ok := v.(collector.Timestamped); ok {
+				ts.Time = time.Time{}
+				got[k] = ts
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: attributes = %v, want %v", tt.name, got, tt.want)
+		}
+		if attrs, _ := cache.Attributes(ctx, collector.Device{ID: "x1"}); len(attrs) != 0 {
+			t.Errorf("%s: unknown device was added to the cache: %v", tt.name, attrs)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Zabbix sender protocol support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"github.com/marcopaganini/smartcollector/pkg/sink"
+	"golang.org/x/net/context"
+)
+
+// Maximum number of values sent in each request (as zabbix_sender does.)
+const zabbixBatchSize = 250
+
+// zabbixHeader starts all Zabbix protocol messages (followed by the protocol
+// flags and the data length.)
+var zabbixHeader = []byte("ZBXD\x01")
+
+// zabbixFailed extracts the number of failed values from a server response.
+var zabbixFailed = regexp.MustCompile(`failed: (\d+)`)
+
+func init() {
+	sink.Register("zabbix", func() (sink.Sink, error) {
+		if *flagZabbixAddr == "" {
+			return nil, nil
+		}
+		return withTimeout(metricsSink(func(ctx context.Context, ts []collector.Metric, t time.Time) error {
+			return sendZabbix(ctx, *flagZabbixAddr, *flagZabbixHost, ts, t)
+		})), nil
+	})
+}
+
+// zabbixValue is a value in a Zabbix sender data request.
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// zabbixKey returns the Zabbix item key for a metric: the metric name, with
+// the device ID and the values of other labels (like component or unit) as
+// parameters (e.g. smartthings_temperature_fahrenheit[<id>,main]).
+func zabbixKey(m collector.Metric) string {
+	params := []string{}
+	if id := m.Label("id"); id != "" {
+		params = append(params, zabbixParam(id))
+	}
+	for _, l := range m.Labels {
+		if l.Value != "" && !contains(deviceLabelNames, l.Name) {
+			params = append(params, zabbixParam(l.Value))
+		}
+	}
+	if len(params) == 0 {
+		return m.Name
+	}
+	return m.Name + "[" + strings.Join(params, ",") + "]"
+}
+
+// zabbixParam returns an item key parameter, quoted if needed.
+func zabbixParam(s string) string {
+	if !strings.ContainsAny(s, `,]["`) && !strings.HasPrefix(s, " ") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// sendZabbix sends the metrics collected at time t to a Zabbix server (or
+// proxy) at addr using the sender (trapper) protocol, as values of the given
+// host. Trapper items with the keys returned by zabbixKey must exist in the
+// host (usually created by low-level discovery); values for missing items
+// are rejected by the server, and reported as an error.
+func sendZabbix(ctx context.Context, addr, host string, ts []collector.Metric, t time.Time) error {
+	failed := 0
+	for start := 0; start < len(ts); start += zabbixBatchSize {
+		end := start + zabbixBatchSize
+		if end > len(ts) {
+			end = len(ts)
+		}
+		values := []zabbixValue{}
+		for _, m := range ts[start:end] {
+			values = append(values, zabbixValue{
+				Host:  host,
+				Key:   zabbixKey(m),
+				Value: strconv.FormatFloat(m.Value, 'g', -1, 64),
+				Clock: t.Unix(),
+			})
+		}
+		n, err := zabbixRequest(ctx, addr, values, t)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+	if failed > 0 {
+		return fmt.Errorf("zabbix rejected %d of %d values (check that the trapper items exist in host %q)", failed, len(ts), host)
+	}
+	return nil
+}
+
+// zabbixRequest sends one sender data request, returning the number of
+// values rejected by the server.
+func zabbixRequest(ctx context.Context, addr string, values []zabbixValue, t time.Time) (int, error) {
+	data, err := json.Marshal(struct {
+		Request string        `json:"request"`
+		Data    []zabbixValue `json:"data"`
+		Clock   int64         `json:"clock"`
+	}{"sender data", values, t.Unix()})
+	if err != nil {
+		return 0, err
+	}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	msg := &bytes.Buffer{}
+	msg.Write(zabbixHeader)
+	binary.Write(msg, binary.LittleEndian, uint64(len(data)))
+	msg.Write(data)
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return 0, err
+	}
+
+	// Response: header, 8 byte length, JSON.
+	hdr := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return 0, fmt.Errorf("error reading zabbix response: %v", err)
+	}
+	if !bytes.HasPrefix(hdr, zabbixHeader[:4]) {
+		return 0, fmt.Errorf("invalid zabbix response header %q", hdr)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(conn, int64(binary.LittleEndian.Uint64(hdr[len(zabbixHeader):]))))
+	if err != nil {
+		return 0, fmt.Errorf("error reading zabbix response: %v", err)
+	}
+	resp := struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, fmt.Errorf("invalid zabbix response: %v", err)
+	}
+	if resp.Response != "success" {
+		return 0, fmt.Errorf("zabbix request failed: %s %s", resp.Response, resp.Info)
+	}
+	if m := zabbixFailed.FindStringSubmatch(resp.Info); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n, nil
+	}
+	return 0, nil
+}
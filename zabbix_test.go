@@ -0,0 +1,177 @@
+// Zabbix sender protocol support for smartcollector.
+//
+// http://github.com/marcopaganini/smartcollector
+// (C) 2016 by Marco Paganini <paganini@paganini.net>
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/smartcollector/pkg/collector"
+	"golang.org/x/net/context"
+)
+
+func TestZabbixKey(t *testing.T) {
+	casetests := []struct {
+		name   string
+		metric collector.Metric
+		want   string
+	}{
+		{
+			name: "device metric",
+			metric: collector.Metric{
+				Name: "smartthings_temperature_fahrenheit",
+				Labels: []collector.Label{
+					{Name: "id", Value: "d1"},
+					{Name: "name", Value: "Fridge"},
+					{Name: "room", Value: "Kitchen"},
+					{Name: "component", Value: "main"},
+				},
+			},
+			want: "smartthings_temperature_fahrenheit[d1,main]",
+		},
+		{
+			name: "quoted parameters",
+			metric: collector.Metric{
+				Name:   "smartthings_switch_on",
+				Labels: []collector.Label{{Name: "id", Value: "d1"}, {Name: "component", Value: `a,"b"`}},
+			},
+			want: `smartthings_switch_on[d1,"a,\"b\""]`,
+		},
+		{
+			name:   "no labels",
+			metric: collector.Metric{Name: "smartcollector_devices_total"},
+			want:   "smartcollector_devices_total",
+		},
+	}
+
+	for _, tt := range casetests {
+		if got := zabbixKey(tt.metric); got != tt.want {
+			t.Errorf("%s: zabbixKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeZabbix is a Zabbix trapper accepting sender data requests, rejecting
+// values for the keys in reject.
+type fakeZabbix struct {
+	ln     net.Listener
+	reject map[string]bool
+
+	mu      sync.Mutex
+	batches []int
+	values  []zabbixValue
+}
+
+func newFakeZabbix(t *testing.T, reject map[string]bool) *fakeZabbix {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := &fakeZabbix{ln: ln, reject: reject}
+	go z.serve(t)
+	return z
+}
+
+func (z *fakeZabbix) serve(t *testing.T) {
+	for {
+		conn, err := z.ln.Accept()
+		if err != nil {
+			return
+		}
+		hdr := make([]byte, len(zabbixHeader)+8)
+		if _, err := io.ReadFull(conn, hdr); err != nil || !bytes.Equal(hdr[:len(zabbixHeader)], zabbixHeader) {
+			t.Errorf("invalid request header %q: %v", hdr, err)
+			conn.Close()
+			continue
+		}
+		req := struct {
+			Request string        `json:"request"`
+			Data    []zabbixValue `json:"data"`
+		}{}
+		data := make([]byte, binary.LittleEndian.Uint64(hdr[len(zabbixHeader):]))
+		if _, err := io.ReadFull(conn, data); err != nil || json.Unmarshal(data, &req) != nil || req.Request != "sender data" {
+			t.Errorf("invalid request %q: %v", data, err)
+			conn.Close()
+			continue
+		}
+
+		failed := 0
+		z.mu.Lock()
+		z.batches = append(z.batches, len(req.Data))
+		z.values = append(z.values, req.Data...)
+		for _, v := range req.Data {
+			if z.reject[v.Key] {
+				failed++
+			}
+		}
+		z.mu.Unlock()
+
+		resp, _ := json.Marshal(map[string]string{
+			"response": "success",
+			"info":     fmt.Sprintf("processed: %d; failed: %d; total: %d; seconds spent: 0.000055", len(req.Data)-failed, failed, len(req.Data)),
+		})
+		msg := &bytes.Buffer{}
+		msg.Write(zabbixHeader)
+		binary.Write(msg, binary.LittleEndian, uint64(len(resp)))
+		msg.Write(resp)
+		conn.Write(msg.Bytes())
+		conn.Close()
+	}
+}
+
+func TestSendZabbix(t *testing.T) {
+	ts := []collector.Metric{}
+	for n := 0; n < 300; n++ {
+		ts = append(ts, collector.Metric{Name: fmt.Sprintf("m%d", n), Value: float64(n) / 2})
+	}
+	now := time.Unix(1462096800, 0)
+
+	casetests := []struct {
+		name    string
+		reject  map[string]bool
+		wantErr bool
+	}{
+		{
+			name: "all accepted",
+		},
+		{
+			name:    "missing items",
+			reject:  map[string]bool{"m1": true, "m299": true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range casetests {
+		z := newFakeZabbix(t, tt.reject)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := sendZabbix(ctx, z.ln.Addr().String(), "smartthings", ts, now)
+		cancel()
+		z.ln.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: sendZabbix error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+
+		z.mu.Lock()
+		if want := []int{250, 50}; !reflect.DeepEqual(z.batches, want) {
+			t.Errorf("%s: batches = %v, want %v", tt.name, z.batches, want)
+		}
+		if len(z.values) == len(ts) {
+			want := zabbixValue{Host: "smartthings", Key: "m3", Value: "1.5", Clock: now.Unix()}
+			if got := z.values[3]; got != want {
+				t.Errorf("%s: value = %+v, want %+v", tt.name, got, want)
+			}
+		}
+		z.mu.Unlock()
+	}
+}